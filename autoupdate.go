@@ -0,0 +1,117 @@
+// Package autoupdate 在 Go 程序中检查、下载和安装更新，不显示界面
+//
+//	u, err := autoupdate.New(
+//		autoupdate.WithDir(installDir),
+//		autoupdate.WithEvents(func(e autoupdate.Event) {
+//			log.Println(e.Type, e.Version, e.Message)
+//		}),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	result, err := u.Check(ctx)
+//	if err != nil || !result.UpdateAvailable {
+//		return err
+//	}
+//	if _, err := u.Download(ctx); err != nil {
+//		return err
+//	}
+//	version, err := u.Apply(ctx)
+//
+// 同一进程中可以创建多个实例，分别管理不同的安装目录。
+package autoupdate
+
+import (
+	"log/slog"
+
+	"autoupdate/internal/updater"
+)
+
+type (
+	// Updater 一个安装目录的更新程序，方法不能并发调用
+	Updater = updater.Updater
+	// Option 配置 New 创建的更新程序
+	Option = updater.Option
+	// Config 更新源、下载、等待和健康检查配置，默认从安装目录中的 updater.ini 读取
+	Config = updater.Config
+	// Event 更新过程中的结构化事件，Type 为 Event* 常量之一
+	Event = updater.Event
+	// CheckResult Check 的结果
+	CheckResult = updater.CheckResult
+	// Status 本地安装的状态
+	Status = updater.Status
+)
+
+// 事件类型
+const (
+	EventCheckStarted  = updater.EventCheckStarted
+	EventVersionFound  = updater.EventVersionFound
+	EventProgress      = updater.EventProgress
+	EventVerified      = updater.EventVerified
+	EventVerifyFailed  = updater.EventVerifyFailed
+	EventInstalled     = updater.EventInstalled
+	EventInstallFailed = updater.EventInstallFailed
+	EventRolledBack    = updater.EventRolledBack
+	EventLog           = updater.EventLog
+	EventError         = updater.EventError
+	EventDone          = updater.EventDone
+)
+
+var (
+	// ErrSignature 版本信息或更新包的签名校验失败
+	ErrSignature = updater.ErrSignature
+	// ErrUnhealthy 新版本没有通过健康检查，已回滚到上一版本
+	ErrUnhealthy = updater.ErrUnhealthy
+	// ErrWaitTimeout 等待宿主程序退出超时
+	ErrWaitTimeout = updater.ErrWaitTimeout
+)
+
+// New 创建不显示界面、不询问确认的更新程序，默认安装目录为可执行文件所在的目录
+func New(opts ...Option) (*Updater, error) {
+	return updater.New(opts...)
+}
+
+// WithAppName 设置应用名称
+func WithAppName(name string) Option {
+	return updater.WithAppName(name)
+}
+
+// WithDir 设置安装目录，版本文件、updater.ini 和下载的文件都位于其中
+func WithDir(dir string) Option {
+	return updater.WithDir(dir)
+}
+
+// WithChannel 使用指定的发布通道，不保存到通道文件
+func WithChannel(name string) Option {
+	return updater.WithChannel(name)
+}
+
+// WithManifestURL 替换 updater.ini 中的版本信息地址
+func WithManifestURL(url string) Option {
+	return updater.WithManifestURL(url)
+}
+
+// WithTrustedKeys 校验签名的根公钥，多个公钥以逗号分隔（base64 编码）
+func WithTrustedKeys(keys string) Option {
+	return updater.WithTrustedKeys(keys)
+}
+
+// WithAllowMD5 允许只提供 MD5 摘要的版本信息
+func WithAllowMD5() Option {
+	return updater.WithAllowMD5()
+}
+
+// WithEvents 把进度、日志和状态变化作为事件交给 handler，handler 不会被并发调用
+func WithEvents(handler func(Event)) Option {
+	return updater.WithEvents(handler)
+}
+
+// WithLogHandler 把日志同时交给 h，例如宿主程序自己的 slog 日志
+func WithLogHandler(h slog.Handler) Option {
+	return updater.WithLogHandler(h)
+}
+
+// WithConfig 在读取 updater.ini 之后修改配置
+func WithConfig(fn func(c *Config)) Option {
+	return updater.WithConfig(fn)
+}
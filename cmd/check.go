@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"autoupdate/internal/updater"
+)
+
+// checkOutput -check -json 的输出
+type checkOutput struct {
+	updater.CheckResult
+	Error string `json:"error,omitempty"`
+}
+
+// runCheck 只检查新版本并把结果输出到 stdout，返回退出码
+func runCheck(ctx context.Context, worker *updater.Updater) int {
+	result, err := worker.Check(ctx)
+	code := updater.CheckExitCode(ctx, result, err)
+
+	if jsonOutput {
+		out := checkOutput{CheckResult: result}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		json.NewEncoder(os.Stdout).Encode(out)
+		return code
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Check failed: %v\n", err)
+		return code
+	}
+	if !result.UpdateAvailable {
+		fmt.Printf("No update available (current %s, latest %s)\n", result.CurrentVersion, result.Version)
+		return code
+	}
+
+	fmt.Printf("Update available: %s -> %s\n", result.CurrentVersion, result.Version)
+	fmt.Printf("Channel: %s\n", result.Channel)
+	if result.Size > 0 {
+		fmt.Printf("Size: %d bytes\n", result.Size)
+	}
+	if result.Mandatory {
+		fmt.Println("Mandatory: yes")
+	} else {
+		fmt.Println("Mandatory: no")
+	}
+	if result.Notes != "" {
+		fmt.Printf("Notes: %s\n", result.Notes)
+	}
+	return code
+}
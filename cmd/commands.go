@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"autoupdate/internal/updater"
+)
+
+// command 可以单独运行的一个步骤，每个命令有自己的参数和退出码
+type command struct {
+	name    string
+	summary string
+	flags   func(fs *flag.FlagSet)
+	run     func(c *command, args []string) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:    "check",
+			summary: "Check for a new version without downloading (exit 6 if one is available)",
+			flags: func(fs *flag.FlagSet) {
+				addCommonFlags(fs)
+				addSourceFlags(fs)
+				fs.BoolVar(&jsonOutput, "json", false, "Print the result as JSON")
+			},
+			run: runCheckCommand,
+		},
+		{
+			name:    "download",
+			summary: "Download and verify the new version without installing it (exit 7 when staged)",
+			flags: func(fs *flag.FlagSet) {
+				addCommonFlags(fs)
+				addSourceFlags(fs)
+				addJSONFlag(fs)
+			},
+			run: runDownloadCommand,
+		},
+		{
+			name:    "install",
+			summary: "Install the version staged by download, without network access (exit 1 when installed)",
+			flags: func(fs *flag.FlagSet) {
+				addCommonFlags(fs)
+				fs.BoolVar(&allowMD5, "allow-md5", false, "Accept a staged update that only has an MD5 digest")
+				addInstallFlags(fs)
+				addJSONFlag(fs)
+			},
+			run: runInstallCommand,
+		},
+		{
+			name:    "rollback",
+			summary: "Restore the version replaced by the last update (exit 5 when rolled back)",
+			flags: func(fs *flag.FlagSet) {
+				addCommonFlags(fs)
+				addInstallFlags(fs)
+				addJSONFlag(fs)
+			},
+			run: runRollbackCommand,
+		},
+		{
+			name:    "status",
+			summary: "Show the installed, staged and previous versions",
+			flags: func(fs *flag.FlagSet) {
+				addCommonFlags(fs)
+				fs.BoolVar(&jsonOutput, "json", false, "Print the status as JSON")
+			},
+			run: runStatusCommand,
+		},
+		{
+			name:    "clean",
+			summary: "Delete downloads and staged updates from tmp/",
+			flags: func(fs *flag.FlagSet) {
+				addCommonFlags(fs)
+				fs.BoolVar(&cleanAll, "all", false, "Also delete the previous version and the kept package (disables rollback and delta updates)")
+				fs.BoolVar(&cleanFailed, "failed", false, "Also forget versions that were rolled back, so they are tried again")
+			},
+			run: runCleanCommand,
+		},
+	}
+}
+
+var (
+	cleanAll    bool
+	cleanFailed bool
+)
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// setup 解析命令参数并创建不显示界面的更新程序
+//
+// -json 时向 stdout 输出 JSON 事件，否则把日志写入 stderr；quiet 时只在 -debug 下输出日志。
+func (c *command) setup(args []string, quiet bool, saveChannel bool) (*updater.Updater, bool) {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n%s\n\nFlags:\n", os.Args[0], c.name, c.summary)
+		fs.PrintDefaults()
+	}
+	c.flags(fs)
+	fs.Parse(args)
+	if appName == "" {
+		appName = "Updater"
+	}
+
+	var ui updater.UI
+	switch {
+	case quiet && !debug:
+		ui = updater.NewLogUI(ioutil.Discard)
+	case quiet || !jsonOutput:
+		ui = updater.NewLogUI(os.Stderr)
+	default:
+		ui = updater.NewJSONUI(os.Stdout)
+	}
+	worker := updater.NewUpdaterWithUI(appName, debug, true, ui)
+
+	if err := configure(worker, fs.Args(), saveChannel); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return worker, false
+	}
+	return worker, true
+}
+
+// signalContext Ctrl+C 或终止信号取消正在进行的命令
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func runCheckCommand(c *command, args []string) int {
+	worker, ok := c.setup(args, true, false)
+	if !ok {
+		return updater.ExitCodeError
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	return runCheck(ctx, worker)
+}
+
+func runDownloadCommand(c *command, args []string) int {
+	worker, ok := c.setup(args, false, true)
+	if !ok {
+		return updater.ExitCodeError
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	return worker.DownloadContext(ctx)
+}
+
+func runInstallCommand(c *command, args []string) int {
+	worker, ok := c.setup(args, false, true)
+	if !ok {
+		return updater.ExitCodeError
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	return worker.InstallContext(ctx)
+}
+
+func runRollbackCommand(c *command, args []string) int {
+	worker, ok := c.setup(args, false, true)
+	if !ok {
+		return updater.ExitCodeError
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	return worker.RollbackContext(ctx)
+}
+
+func runStatusCommand(c *command, args []string) int {
+	worker, ok := c.setup(args, true, true)
+	if !ok {
+		return updater.ExitCodeError
+	}
+
+	status := worker.Status()
+	if jsonOutput {
+		json.NewEncoder(os.Stdout).Encode(status)
+		return updater.ExitCodeNoUpdate
+	}
+	printStatus(os.Stdout, status)
+	return updater.ExitCodeNoUpdate
+}
+
+func printStatus(w io.Writer, s updater.Status) {
+	orNone := func(v string) string {
+		if v == "" {
+			return "none"
+		}
+		return v
+	}
+	fmt.Fprintf(w, "Version:  %s\n", s.Version)
+	fmt.Fprintf(w, "Channel:  %s\n", s.Channel)
+	fmt.Fprintf(w, "Staged:   %s\n", orNone(s.Staged))
+	fmt.Fprintf(w, "Previous: %s\n", orNone(s.Previous))
+	fmt.Fprintf(w, "Failed:   %s\n", orNone(strings.Join(s.Failed, ", ")))
+}
+
+func runCleanCommand(c *command, args []string) int {
+	worker, ok := c.setup(args, true, true)
+	if !ok {
+		return updater.ExitCodeError
+	}
+
+	if err := worker.Clean(cleanAll); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return updater.ExitCodeError
+	}
+	if cleanFailed {
+		if err := worker.ForgetFailedVersions(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return updater.ExitCodeError
+		}
+	}
+	return updater.ExitCodeNoUpdate
+}
@@ -1,44 +1,228 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 
 	"autoupdate/internal/updater"
 )
 
 var (
-	appName string
-	debug   bool
-	silent  bool
+	appName  string
+	debug    bool
+	silent   bool
+	allowMD5 bool
+	channel  string
+
+	manifestURL string
+	releaseURL  string
+	variant     string
+	connections int
+	insecure    bool
+
+	waitPID     int
+	waitTimeout time.Duration
+	lockFile    string
+	closeApp    string
+
+	relaunch    bool
+	relaunchDir string
+
+	jsonOutput bool
+	checkOnly  bool
+
+	logLevel string
+	logFile  string
 )
 
+// addCommonFlags 所有命令共用的参数
+func addCommonFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&debug, "debug", false, "Debug mode")
+	fs.StringVar(&appName, "app", "", "Application name")
+	fs.StringVar(&logLevel, "log-level", "", "Log level for the window, console and log file (debug/info/warn/error, default info)")
+	fs.StringVar(&logFile, "log-file", "", "Write the log file here instead of updater.log (\"none\" disables it)")
+}
+
+// addSourceFlags 检查和下载更新时使用的参数
+func addSourceFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&allowMD5, "allow-md5", false, "Accept manifests that only provide an MD5 digest")
+	fs.StringVar(&channel, "channel", "", "Release channel (stable/beta/nightly), saved for later runs")
+	fs.StringVar(&manifestURL, "manifest-url", "", "Override the manifest (ver.ini) URL")
+	fs.StringVar(&releaseURL, "release-url", "", "Override the package URL template (version, filename)")
+	fs.StringVar(&variant, "variant", "", "Package variant for this platform (e.g. musl, portable)")
+	fs.IntVar(&connections, "connections", 0, "Parallel connections for chunked downloads (1 disables chunking)")
+	fs.BoolVar(&insecure, "insecure-skip-verify", false, "Skip TLS certificate verification (only with -debug)")
+}
+
+// addInstallFlags 替换文件前后使用的参数
+func addInstallFlags(fs *flag.FlagSet) {
+	fs.IntVar(&waitPID, "wait-pid", 0, "Wait for this process to exit before installing")
+	fs.DurationVar(&waitTimeout, "wait-timeout", 0, "How long to wait for the application to exit (default 1m)")
+	fs.StringVar(&lockFile, "lock-file", "", "Wait until the application releases this lock file")
+	fs.StringVar(&closeApp, "close-app", "", "Ask the application to close before waiting (none/signal/ipc)")
+	fs.BoolVar(&relaunch, "relaunch", false, "Start the command given after -- once the update is installed")
+	fs.StringVar(&relaunchDir, "relaunch-dir", "", "Working directory for -relaunch")
+}
+
+func addJSONFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&jsonOutput, "json", false, "Write newline-delimited JSON events to stdout (implies -silent)")
+}
+
 func init() {
-	flag.BoolVar(&debug, "debug", false, "Debug mode")
+	addCommonFlags(flag.CommandLine)
+	addSourceFlags(flag.CommandLine)
+	addInstallFlags(flag.CommandLine)
+	addJSONFlag(flag.CommandLine)
 	flag.BoolVar(&silent, "silent", false, "Silent mode")
-	flag.StringVar(&appName, "app", "", "Application name")
-	flag.Parse()
+	flag.BoolVar(&checkOnly, "check", false, "Only check for a new version and print the result (exit code 6 if one is available)")
+	flag.Usage = usage
+}
 
-	if appName == "" {
-		appName = "Updater"
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nWithout a command the updater checks, downloads and installs in one run.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// configure 把命令行参数应用到更新程序，args 为 -- 之后要重新启动的程序
+func configure(worker *updater.Updater, args []string, saveChannel bool) error {
+	worker.AllowMD5 = allowMD5
+
+	if insecure && !debug {
+		return errors.New("-insecure-skip-verify 只能与 -debug 一起使用")
+	}
+	worker.InsecureSkipVerify = insecure
+
+	if logLevel != "" {
+		level, err := updater.ParseLogLevel(logLevel)
+		if err != nil {
+			return err
+		}
+		worker.Config.Log.Level = level
+	}
+	if logFile != "" {
+		worker.Config.Log.File = logFile
+	}
+
+	// 宿主程序把自己的命令行放在 -- 之后: updater -relaunch -relaunch-dir <dir> -- app.exe --arg
+	if relaunch {
+		if len(args) == 0 {
+			return errors.New("-relaunch 需要在 -- 之后指定要启动的程序")
+		}
+		worker.Relaunch = &updater.Relaunch{
+			Args:  args,
+			Dir:   relaunchDir,
+			Grace: updater.DefaultRelaunchGrace,
+		}
+	}
+
+	if manifestURL != "" {
+		worker.Config.ManifestURL = manifestURL
+	}
+	if releaseURL != "" {
+		worker.Config.ReleaseURL = releaseURL
+	}
+	if variant != "" {
+		worker.Config.Variant = variant
+	}
+	if connections > 0 {
+		worker.Config.Connections = connections
+	}
+	if waitPID != 0 {
+		worker.Config.Wait.PID = waitPID
 	}
+	if waitTimeout != 0 {
+		worker.Config.Wait.Timeout = waitTimeout
+	}
+	if lockFile != "" {
+		worker.Config.Wait.LockFile = lockFile
+	}
+	if closeApp != "" {
+		worker.Config.Wait.Close = closeApp
+	}
+
+	if channel != "" {
+		setChannel := worker.SetChannel
+		if !saveChannel {
+			setChannel = worker.UseChannel
+		}
+		if err := setChannel(channel); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func main() {
 
+	// 第一个参数是命令时按命令运行，否则检查、下载并安装
+	if len(os.Args) > 1 {
+		if c := findCommand(os.Args[1]); c != nil {
+			os.Exit(c.run(c, os.Args[2:]))
+		}
+	}
+
+	flag.Parse()
+	if appName == "" {
+		appName = "Updater"
+	}
+
 	runtime.LockOSThread()
 
-	worker := updater.NewUpdater(appName, debug, silent)
+	var worker *updater.Updater
+	switch {
+	case checkOnly:
+		// 只检查时不显示界面，stdout 只输出检查结果，调试模式下日志输出到 stderr
+		logOut := ioutil.Discard
+		if debug {
+			logOut = os.Stderr
+		}
+		worker = updater.NewUpdaterWithUI(appName, debug, true, updater.NewLogUI(logOut))
+	case jsonOutput:
+		// stdout 只输出 JSON 事件，不显示界面也不等待确认
+		worker = updater.NewUpdaterWithUI(appName, debug, true, updater.NewJSONUI(os.Stdout))
+	default:
+		worker = updater.NewUpdater(appName, debug, silent)
+	}
+
+	// 只检查时不保存通道
+	if err := configure(worker, flag.Args(), !checkOnly); err != nil {
+		worker.UI.ShowUpdateErrorDialog(err.Error())
+		os.Exit(updater.ExitCodeError)
+	}
+
+	// Ctrl+C 或终止信号取消正在进行的更新
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	if checkOnly {
+		code := runCheck(ctx, worker)
+		stop()
+		os.Exit(code)
+	}
 
 	var result int
 
 	go func(result *int) {
-		*result = worker.Update()
+		*result = worker.UpdateContext(ctx)
+		worker.UI.UpdateFinished()
 	}(&result)
 
-	updater.AppLoop()
+	worker.UI.AppLoop()
 
+	stop()
 	os.Exit(result)
 
 }
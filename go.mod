@@ -1,6 +1,6 @@
 module autoupdate
 
-go 1.16
+go 1.21
 
 require (
 	github.com/JamesHovious/w32 v1.2.0
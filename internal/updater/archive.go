@@ -0,0 +1,180 @@
+package updater
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DefaultMaxEntries      = 100000
+	DefaultMaxUnpackedSize = 4 << 30 // 4GB
+
+	SymlinkReject = "reject"
+	SymlinkSkip   = "skip"
+	SymlinkAllow  = "allow"
+
+	maxSymlinkTarget = 4096
+)
+
+var (
+	ErrAbsolutePath   = errors.New("不允许绝对路径")
+	ErrPathTraversal  = errors.New("路径超出安装目录")
+	ErrSpecialFile    = errors.New("不允许设备文件或其他特殊文件")
+	ErrSymlink        = errors.New("不允许的符号链接")
+	ErrTooManyEntries = errors.New("条目数量超过限制")
+	ErrTooLarge       = errors.New("解压后大小超过限制")
+)
+
+// ArchiveError 更新包中不安全的条目，Err 为上面的错误之一
+type ArchiveError struct {
+	Entry string
+	Err   error
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("更新包条目 %q: %v", e.Entry, e.Err)
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// ArchiveLimits 解压更新包时的限制
+type ArchiveLimits struct {
+	MaxEntries      int
+	MaxUnpackedSize int64
+	// SymlinkPolicy 符号链接的处理方式: reject 拒绝整个更新包，skip 忽略，
+	// allow 允许指向安装目录内的相对链接
+	SymlinkPolicy string
+}
+
+// DefaultArchiveLimits 默认限制
+func DefaultArchiveLimits() ArchiveLimits {
+	return ArchiveLimits{
+		MaxEntries:      DefaultMaxEntries,
+		MaxUnpackedSize: DefaultMaxUnpackedSize,
+		SymlinkPolicy:   SymlinkReject,
+	}
+}
+
+// checkArchive 在解压前检查条目数量和声明的解压后大小
+func checkArchive(files []*zip.File, limits ArchiveLimits) error {
+	if limits.MaxEntries > 0 && len(files) > limits.MaxEntries {
+		return &ArchiveError{Entry: fmt.Sprintf("共 %d 个", len(files)), Err: ErrTooManyEntries}
+	}
+
+	var total uint64
+	for _, file := range files {
+		total += file.UncompressedSize64
+		if limits.MaxUnpackedSize > 0 && total > uint64(limits.MaxUnpackedSize) {
+			return &ArchiveError{Entry: file.Name, Err: ErrTooLarge}
+		}
+	}
+
+	return nil
+}
+
+// archiveEntryPath 检查条目名并返回清理后的相对路径（使用 "/" 分隔）
+func archiveEntryPath(name string) (string, error) {
+	// ZIP 规范使用 "/"，但 Windows 上生成的包可能包含 "\"
+	p := strings.Replace(name, "\\", "/", -1)
+
+	if strings.HasPrefix(p, "/") || filepath.VolumeName(name) != "" ||
+		(len(p) >= 2 && p[1] == ':') {
+		return "", &ArchiveError{Entry: name, Err: ErrAbsolutePath}
+	}
+
+	p = path.Clean(p)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", &ArchiveError{Entry: name, Err: ErrPathTraversal}
+	}
+
+	return p, nil
+}
+
+// containedPath 将相对路径拼接到 root 下，并确认结果没有离开 root
+func containedPath(root string, rel string) (string, error) {
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	r, err := filepath.Rel(root, target)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", &ArchiveError{Entry: rel, Err: ErrPathTraversal}
+	}
+	return target, nil
+}
+
+// checkEntryMode 拒绝设备文件、管道等特殊文件
+func checkEntryMode(file *zip.File) error {
+	special := os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe | os.ModeSocket | os.ModeIrregular
+	if file.Mode()&special != 0 {
+		return &ArchiveError{Entry: file.Name, Err: ErrSpecialFile}
+	}
+	return nil
+}
+
+// readSymlinkTarget 读取符号链接条目的目标，并检查它是否指向 root 内
+//
+// 返回清理后的目标，".." 只会出现在开头。配合 checkParentDirs 保证各级父目录都不是
+// 符号链接，按字面解析的结果就是实际解析的结果，多个符号链接串联也不会离开 root。
+func readSymlinkTarget(file *zip.File, rel string) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("打开 ZIP 文件内容失败: %v", err)
+	}
+	defer src.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(src, maxSymlinkTarget+1))
+	if err != nil {
+		return "", fmt.Errorf("读取符号链接失败: %v", err)
+	}
+	if len(data) > maxSymlinkTarget {
+		return "", &ArchiveError{Entry: file.Name, Err: ErrSymlink}
+	}
+
+	target := string(data)
+	slashed := strings.Replace(target, "\\", "/", -1)
+	if target == "" || path.IsAbs(slashed) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return "", &ArchiveError{Entry: file.Name, Err: ErrSymlink}
+	}
+
+	resolved := path.Clean(path.Join(path.Dir(rel), slashed))
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", &ArchiveError{Entry: file.Name, Err: ErrPathTraversal}
+	}
+
+	return filepath.FromSlash(path.Clean(slashed)), nil
+}
+
+// checkParentDirs 确认 rel 在 root 中的各级父目录都不是符号链接，
+// 防止通过之前解压的符号链接把文件写到别处
+func checkParentDirs(root string, rel string) error {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(dir)))
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return &ArchiveError{Entry: rel, Err: ErrSymlink}
+		}
+	}
+	return nil
+}
+
+// limitedWriter 在写入超过剩余额度时返回 ErrTooLarge，防止条目头中声明的大小与实际不符
+type limitedWriter struct {
+	w         io.Writer
+	remaining *int64
+	entry     string
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > *lw.remaining {
+		return 0, &ArchiveError{Entry: lw.entry, Err: ErrTooLarge}
+	}
+	n, err := lw.w.Write(p)
+	*lw.remaining -= int64(n)
+	return n, err
+}
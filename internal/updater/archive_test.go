@@ -0,0 +1,159 @@
+package updater
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+type testEntry struct {
+	name string
+	body string
+	mode os.FileMode
+}
+
+// writeTestZip 在临时目录中生成包含 entries 的 ZIP 文件
+func writeTestZip(t *testing.T, entries []testEntry) string {
+	t.Helper()
+
+	zipPath := filepath.Join(t.TempDir(), "package.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header.SetMode(mode)
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return zipPath
+}
+
+func stageTestZip(t *testing.T, entries []testEntry, limits ArchiveLimits) (*installTransaction, error) {
+	t.Helper()
+
+	tx, err := newInstallTransaction(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx, tx.stageZip(writeTestZip(t, entries), limits)
+}
+
+func TestStageZipRejectsEntries(t *testing.T) {
+	allow := DefaultArchiveLimits()
+	allow.SymlinkPolicy = SymlinkAllow
+
+	tests := []struct {
+		name    string
+		entries []testEntry
+		limits  ArchiveLimits
+		want    error
+	}{
+		{"路径穿越", []testEntry{{name: "../evil.txt"}}, DefaultArchiveLimits(), ErrPathTraversal},
+		{"中间的路径穿越", []testEntry{{name: "a/../../evil.txt"}}, DefaultArchiveLimits(), ErrPathTraversal},
+		{"绝对路径", []testEntry{{name: "/etc/passwd"}}, DefaultArchiveLimits(), ErrAbsolutePath},
+		{"Windows 盘符", []testEntry{{name: "C:\\Windows\\evil.dll"}}, DefaultArchiveLimits(), ErrAbsolutePath},
+		{"管道", []testEntry{{name: "fifo", mode: os.ModeNamedPipe | 0644}}, DefaultArchiveLimits(), ErrSpecialFile},
+		{"默认拒绝符号链接", []testEntry{{name: "link", body: "target", mode: os.ModeSymlink | 0777}}, DefaultArchiveLimits(), ErrSymlink},
+		{"符号链接指向上级目录", []testEntry{{name: "link", body: "../outside", mode: os.ModeSymlink | 0777}}, allow, ErrPathTraversal},
+		{"符号链接指向绝对路径", []testEntry{{name: "link", body: "/etc", mode: os.ModeSymlink | 0777}}, allow, ErrSymlink},
+		{"通过符号链接写入文件", []testEntry{
+			{name: "link", body: "dir", mode: os.ModeSymlink | 0777},
+			{name: "link/file.txt", body: "x"},
+		}, allow, ErrSymlink},
+		{"条目过多", []testEntry{{name: "a"}, {name: "b"}, {name: "c"}}, ArchiveLimits{MaxEntries: 2}, ErrTooManyEntries},
+		{"解压后过大", []testEntry{{name: "a", body: "0123456789"}}, ArchiveLimits{MaxUnpackedSize: 5}, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := stageTestZip(t, tt.entries, tt.limits)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("stageZip() = %v, want %v", err, tt.want)
+			}
+			var archiveErr *ArchiveError
+			if !errors.As(err, &archiveErr) {
+				t.Fatalf("stageZip() = %T, want *ArchiveError", err)
+			}
+		})
+	}
+}
+
+func TestStageZipSkipsSymlinks(t *testing.T) {
+	limits := DefaultArchiveLimits()
+	limits.SymlinkPolicy = SymlinkSkip
+
+	tx, err := stageTestZip(t, []testEntry{
+		{name: "app.txt", body: "app"},
+		{name: "link", body: "../outside", mode: os.ModeSymlink | 0777},
+	}, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.files) != 1 || tx.files[0] != "app.txt" {
+		t.Fatalf("files = %v, want [app.txt]", tx.files)
+	}
+}
+
+func TestStageZipSymlinkChain(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("需要创建符号链接的权限")
+	}
+	limits := DefaultArchiveLimits()
+	limits.SymlinkPolicy = SymlinkAllow
+
+	// p/q/x 指向 root，p/q/l 按字面解析为 p/q/y，经过 x 实际解析却会离开 root
+	tx, err := stageTestZip(t, []testEntry{
+		{name: "p/q/x", body: "../..", mode: os.ModeSymlink | 0777},
+		{name: "p/q/l", body: "x/../y", mode: os.ModeSymlink | 0777},
+	}, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := os.Readlink(filepath.Join(tx.stagingDir, "p", "q", "l"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "y" {
+		t.Fatalf("link target = %q, want %q", target, "y")
+	}
+}
+
+func TestArchiveEntryPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"app.exe", "app.exe"},
+		{"lib/./a.so", "lib/a.so"},
+		{"lib\\win\\a.dll", "lib/win/a.dll"},
+		{"a/b/../c", "a/c"},
+		{"dir/", "dir"},
+	}
+	for _, tt := range tests {
+		got, err := archiveEntryPath(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("archiveEntryPath(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
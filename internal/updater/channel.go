@@ -0,0 +1,83 @@
+package updater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	ChannelFile = "channel.ini"
+
+	ChannelStable  = "stable"
+	ChannelBeta    = "beta"
+	ChannelNightly = "nightly"
+)
+
+// channelRank 通道越靠后越激进，选择某个通道时也会接收更稳定通道的版本
+var channelRank = map[string]int{
+	ChannelStable:  0,
+	ChannelBeta:    1,
+	ChannelNightly: 2,
+}
+
+func normalizeChannel(name string) (string, error) {
+	channel := strings.ToLower(strings.TrimSpace(name))
+	if channel == "" {
+		return ChannelStable, nil
+	}
+	if _, ok := channelRank[channel]; !ok {
+		return "", fmt.Errorf("未知的发布通道: %s", name)
+	}
+	return channel, nil
+}
+
+// readChannelFile 读取本机保存的发布通道，文件不存在时使用 stable
+func readChannelFile(filePath string) (string, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return ChannelStable, nil
+	}
+
+	cfg, err := ini.Load(content)
+	if err != nil {
+		return ChannelStable, fmt.Errorf("无法解析通道配置: %v", err)
+	}
+
+	return normalizeChannel(cfg.Section("").Key("channel").String())
+}
+
+func writeChannelFile(filePath string, channel string) error {
+	content := fmt.Sprintf("channel=%s\n", channel)
+	if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("保存通道配置失败: %v", err)
+	}
+	return nil
+}
+
+// selectRelease 选出指定通道可用的最高版本
+func selectRelease(releases []VersionInfo, channel string) (VersionInfo, error) {
+	var best VersionInfo
+	var bestVer Version
+	found := false
+
+	for _, release := range releases {
+		if channelRank[release.Channel] > channelRank[channel] {
+			continue
+		}
+		v, err := ParseVersion(release.Version)
+		if err != nil {
+			return best, err
+		}
+		if !found || v.Compare(bestVer) > 0 {
+			best, bestVer, found = release, v, true
+		}
+	}
+
+	if !found {
+		return best, fmt.Errorf("通道 %s 没有可用的版本", channel)
+	}
+	return best, nil
+}
@@ -0,0 +1,136 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CheckResult 只检查时的结果
+type CheckResult struct {
+	CurrentVersion string `json:"current_version"`
+	Version        string `json:"version"`
+	Channel        string `json:"channel"`
+	Size           int64  `json:"size"`
+	Notes          string `json:"notes"`
+	Mandatory      bool   `json:"mandatory"`
+	// UpdateAvailable 服务器版本可以安装：比当前版本新，或者服务器要求回退，
+	// 并且没有因为健康检查失败被跳过
+	UpdateAvailable bool `json:"update_available"`
+}
+
+// Check 只检查是否有可以安装的新版本，不下载，也不写入安装目录中的任何文件
+func (u *Updater) Check(ctx context.Context) (CheckResult, error) {
+	// 只检查时不写入日志文件
+	u.startRun("check", false)
+	u.phase(phaseCheck)
+	result := CheckResult{CurrentVersion: u.CurrentVer.Version, Channel: u.Channel}
+
+	if err := u.prepare(); err != nil {
+		return result, fmt.Errorf("读取配置失败: %v", err)
+	}
+
+	// 镜像失败记录和公钥轮换结果保存在安装目录中，只检查时不写入
+	u.health.readOnly = true
+	u.checkOnly = true
+	defer func() {
+		u.health.readOnly = false
+		u.checkOnly = false
+	}()
+
+	u.emit(Event{Type: EventCheckStarted, CurrentVersion: u.CurrentVer.Version, Channel: u.Channel})
+
+	latest, err := u.checkLatestVersion(ctx)
+	if err != nil {
+		return result, err
+	}
+	cmp, err := CompareVersions(latest.Version, u.CurrentVer.Version)
+	if err != nil {
+		return result, err
+	}
+
+	result.Version = latest.Version
+	result.Channel = latest.Channel
+	result.Size = latest.Size
+	result.Notes = latest.Notes
+	result.Mandatory = latest.Mandatory
+	result.UpdateAvailable = (cmp > 0 || (cmp < 0 && latest.Rollback)) && !u.versionFailed(latest.Version)
+
+	u.emit(Event{
+		Type:            EventVersionFound,
+		CurrentVersion:  result.CurrentVersion,
+		Version:         result.Version,
+		Channel:         result.Channel,
+		Size:            result.Size,
+		Notes:           result.Notes,
+		UpdateAvailable: &result.UpdateAvailable,
+	})
+	return result, nil
+}
+
+// CheckExitCode Check 的结果对应的退出码
+func CheckExitCode(ctx context.Context, result CheckResult, err error) int {
+	switch {
+	case err == nil && result.UpdateAvailable:
+		return ExitCodeUpdateAvailable
+	case err == nil:
+		return ExitCodeNoUpdate
+	case ctx.Err() != nil:
+		return ExitCodeCancel
+	case errors.Is(err, ErrSignature):
+		return ExitCodeSignature
+	}
+	return ExitCodeError
+}
+
+// logUI 只把日志逐行写入 out 的界面，不显示窗口也不等待确认
+type logUI struct {
+	mu  sync.Mutex
+	out io.Writer
+
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// NewLogUI 创建只输出日志文本的界面，用于 -check 等不显示界面的模式
+func NewLogUI(out io.Writer) UI {
+	return &logUI{out: out, quit: make(chan struct{})}
+}
+
+func (l *logUI) ShowMainWindow() {}
+
+func (l *logUI) AppendLogText(text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintln(l.out, text)
+}
+
+func (l *logUI) SetUpdateProgress(progress float64) {}
+
+func (l *logUI) SetUpdateComplete() {}
+
+func (l *logUI) ShowUpdateErrorDialog(message string) {
+	l.AppendLogText("Update Error: " + message)
+}
+
+// ShowUpdateConfirmDialog 没有交互，始终确认
+func (l *logUI) ShowUpdateConfirmDialog(message string) bool {
+	return true
+}
+
+func (l *logUI) CloseWindow() {}
+
+func (l *logUI) IsUpdateCancelled() bool {
+	return false
+}
+
+func (l *logUI) AppLoop() {
+	<-l.quit
+}
+
+func (l *logUI) UpdateFinished() {
+	l.quitOnce.Do(func() { close(l.quit) })
+}
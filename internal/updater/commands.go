@@ -0,0 +1,249 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Status 本地安装的状态
+type Status struct {
+	Version string `json:"version"`
+	Channel string `json:"channel"`
+	// Staged 已下载、等待安装的版本
+	Staged string `json:"staged,omitempty"`
+	// Previous 可以回滚到的上一版本
+	Previous string `json:"previous,omitempty"`
+	// Failed 被回滚、不再安装的版本
+	Failed []string `json:"failed,omitempty"`
+}
+
+// DownloadContext 检查并下载新版本，校验后保存在 TempDir 中等待 InstallContext 安装
+//
+// 除 TempDir 外不修改安装目录。下载完成或已经下载过时返回 ExitCodeStaged。
+func (u *Updater) DownloadContext(ctx context.Context) int {
+	u.startRun("download", true)
+	return u.finish(u.downloadOnly(ctx))
+}
+
+func (u *Updater) downloadOnly(ctx context.Context) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go u.watchCancel(ctx, cancel)
+
+	if _, code, ok := u.findUpdate(ctx); !ok {
+		return code
+	}
+
+	if u.NewVer.MinVersion != "" {
+		if c, _ := CompareVersions(u.CurrentVer.Version, u.NewVer.MinVersion); c < 0 {
+			u.lastErr = fmt.Errorf("当前版本低于 %s，无法直接更新到 %s", u.NewVer.MinVersion, u.NewVer.Version)
+			u.log.Error(u.lastErr.Error(), "min_version", u.NewVer.MinVersion)
+			return ExitCodeError
+		}
+	}
+
+	if staged, err := u.loadStaged(); err == nil && staged.Version.Version == u.NewVer.Version && staged.Variant == u.Config.Variant {
+		u.log.Info(fmt.Sprintf("版本 %s 已下载，等待安装", u.NewVer.Version), "version", u.NewVer.Version)
+		return ExitCodeStaged
+	}
+	// 之前下载的其他版本不再安装
+	u.clearStaged()
+
+	u.phase(phaseDownload)
+	stopSync := u.syncUI()
+	staged, err := u.stage(ctx)
+	if err == nil {
+		err = u.saveStaged(staged)
+	}
+	stopSync()
+
+	if err != nil {
+		u.lastErr = err
+		u.log.Error(fmt.Sprintf("下载失败: %v", err), "version", u.NewVer.Version)
+		if ctx.Err() != nil {
+			return ExitCodeCancel
+		}
+		if errors.Is(err, ErrSignature) {
+			return ExitCodeSignature
+		}
+		return ExitCodeError
+	}
+
+	u.log.Info(fmt.Sprintf("版本 %s 已下载，等待安装", u.NewVer.Version), "version", u.NewVer.Version)
+	return ExitCodeStaged
+}
+
+// InstallContext 安装 DownloadContext 下载的更新，不访问网络
+//
+// 安装后按配置重新启动程序并执行健康检查。没有已下载的更新时返回 ExitCodeNoUpdate。
+func (u *Updater) InstallContext(ctx context.Context) int {
+	u.startRun("install", true)
+	return u.finish(u.installStaged(ctx))
+}
+
+func (u *Updater) installStaged(ctx context.Context) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go u.watchCancel(ctx, cancel)
+
+	if err := u.prepare(); err != nil {
+		u.lastErr = err
+		u.log.Error(fmt.Sprintf("读取配置失败: %v", err))
+		return ExitCodeError
+	}
+
+	u.phase(phaseInstall)
+	staged, err := u.loadStaged()
+	if errors.Is(err, ErrNotStaged) {
+		u.log.Info(err.Error())
+		return ExitCodeNoUpdate
+	} else if err != nil {
+		u.lastErr = err
+		u.log.Error(err.Error())
+		if errors.Is(err, ErrSignature) {
+			return ExitCodeSignature
+		}
+		return ExitCodeError
+	}
+
+	cmp, err := CompareVersions(staged.Version.Version, u.CurrentVer.Version)
+	if err != nil {
+		u.lastErr = err
+		u.log.Error(err.Error())
+		return ExitCodeError
+	}
+	if cmp == 0 || (cmp < 0 && !staged.Version.Rollback) {
+		u.log.Info(fmt.Sprintf("已下载的版本 %s 不需要安装", staged.Version.Version), "version", staged.Version.Version)
+		u.clearStaged()
+		return ExitCodeNoUpdate
+	}
+
+	u.NewVer = staged.Version
+	u.log.Info(fmt.Sprintf("安装版本 %s...", u.NewVer.Version), "current_version", u.CurrentVer.Version, "version", u.NewVer.Version)
+
+	tx, err := u.applyStaged(ctx, staged)
+	if err == nil {
+		err = u.finishInstall(staged, tx)
+	}
+	if err != nil {
+		u.lastErr = err
+		u.log.Error(fmt.Sprintf("更新失败: %v", err), "version", u.NewVer.Version)
+		if ctx.Err() != nil {
+			return ExitCodeCancel
+		}
+		return ExitCodeError
+	}
+
+	u.log.Info("更新完成", "version", u.NewVer.Version)
+	return u.startAndCheck(ctx)
+}
+
+// RollbackContext 恢复保留的上一版本，被回滚的版本记录在 failed.ini 中不再安装
+//
+// 没有保留上一版本时返回 ExitCodeNoUpdate，回滚成功时返回 ExitCodeRolledBack。
+func (u *Updater) RollbackContext(ctx context.Context) int {
+	u.startRun("rollback", true)
+	return u.finish(u.rollbackInstalled(ctx))
+}
+
+func (u *Updater) rollbackInstalled(ctx context.Context) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go u.watchCancel(ctx, cancel)
+
+	if err := u.prepare(); err != nil {
+		u.lastErr = err
+		u.log.Error(fmt.Sprintf("读取配置失败: %v", err))
+		return ExitCodeError
+	}
+
+	u.phase(phaseRollback)
+	if _, _, err := u.readPrevious(); errors.Is(err, ErrNoPrevious) {
+		u.log.Info(err.Error())
+		return ExitCodeNoUpdate
+	}
+
+	if err := u.waitForApp(ctx); err != nil {
+		u.lastErr = err
+		u.log.Error(err.Error())
+		if ctx.Err() != nil {
+			return ExitCodeCancel
+		}
+		return ExitCodeError
+	}
+
+	u.NewVer = u.CurrentVer
+	u.log.Info(fmt.Sprintf("回滚版本 %s...", u.CurrentVer.Version), "current_version", u.CurrentVer.Version)
+	version, err := u.rollbackPrevious()
+	if err != nil {
+		u.lastErr = err
+		u.log.Error(err.Error())
+		return ExitCodeError
+	}
+	u.log.Info(fmt.Sprintf("已回滚到版本 %s", version), "version", version, "replaced_version", u.NewVer.Version)
+	u.emit(Event{Type: EventRolledBack, CurrentVersion: version, Version: u.NewVer.Version})
+
+	if err := u.recordFailedVersion(u.NewVer.Version, "手动回滚"); err != nil {
+		u.log.Warn(fmt.Sprintf("记录回滚的版本失败: %v", err))
+	}
+	u.reloadVersion()
+
+	if u.Relaunch != nil {
+		if _, err := u.relaunch(ctx); err != nil {
+			u.log.Error(err.Error())
+		}
+	}
+	return ExitCodeRolledBack
+}
+
+// Status 返回本地安装的版本、已下载的更新和可以回滚的版本，不访问网络
+func (u *Updater) Status() Status {
+	s := Status{
+		Version: u.CurrentVer.Version,
+		Channel: u.Channel,
+		Failed:  u.failedVersions(),
+	}
+	if staged, err := u.loadStaged(); err == nil {
+		s.Staged = staged.Version.Version
+	}
+	if version, _, err := u.readPrevious(); err == nil {
+		s.Previous = version
+	}
+	return s
+}
+
+// Clean 删除 TempDir 中下载的文件和没有安装的更新
+//
+// all 为 true 时同时删除保留的上一版本和更新包，之后无法回滚，也不能使用增量更新。
+func (u *Updater) Clean(all bool) error {
+	entries, err := ioutil.ReadDir(u.tempPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !all && (entry.Name() == PreviousDir || entry.Name() == InstalledDir) {
+			continue
+		}
+		if err := os.RemoveAll(u.tempPath(entry.Name())); err != nil {
+			return fmt.Errorf("清理临时目录失败: %v", err)
+		}
+	}
+	if all {
+		os.Remove(u.tempPath())
+	}
+	return nil
+}
+
+// ForgetFailedVersions 删除 failed.ini，之后重新尝试被回滚的版本
+func (u *Updater) ForgetFailedVersions() error {
+	if err := os.Remove(u.failedVersionsPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,455 @@
+package updater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	ConfigFile = "updater.ini"
+
+	EnvManifestURL = "UPDATER_MANIFEST_URL"
+	EnvReleaseURL  = "UPDATER_RELEASE_URL"
+	EnvMirrors     = "UPDATER_MIRRORS"
+	EnvVariant     = "UPDATER_VARIANT"
+	EnvConnections = "UPDATER_CONNECTIONS"
+)
+
+// Config 更新源配置
+//
+// 优先级从高到低: 命令行参数、环境变量、可执行文件旁的 updater.ini、内置默认值
+//
+//	manifest_url    = https://example.com/app/ver.ini
+//	release_url     = https://example.com/app/%s/%s
+//	weight          = 5
+//	mirrors         = https://ghp.ci, https://mirror.example.com
+//	mirror_order    = weighted
+//	mirror_cooldown = 10m
+//	variant         = musl
+//	connections     = 4
+//
+//	[mirror.cdn]
+//	manifest_url = https://cdn.example.com/app/ver.json
+//	release_url  = https://cdn.example.com/app/%s/%s
+//	weight       = 10
+//
+//	[retry]
+//	attempts      = 4
+//	initial_delay = 1s
+//	max_delay     = 30s
+//	multiplier    = 2
+//
+//	[tls]
+//	ca_bundle = certs/internal-ca.pem
+//
+//	[pins]
+//	update.example.com = sha256/AAAA..., sha256/BBBB...
+//
+//	[archive]
+//	max_entries       = 100000
+//	max_unpacked_size = 4294967296
+//	symlinks          = reject
+//
+//	[wait]
+//	lock_file  = app.lock
+//	timeout    = 60s
+//	close      = ipc
+//	close_addr = 127.0.0.1:47001
+//
+//	[health]
+//	command = ./app --self-test
+//	marker  = started.ok
+//	timeout = 30s
+//
+//	[log]
+//	file      = logs/updater.log
+//	level     = debug
+//	max_size  = 1048576
+//	max_files = 3
+type Config struct {
+	// ManifestURL 版本信息地址，签名与公钥列表位于同一目录
+	ManifestURL string
+	// ReleaseURL 更新包地址模板，依次填入版本号和文件名
+	ReleaseURL string
+	// Weight 主更新源在 weighted 模式下的权重
+	Weight int
+	// Mirrors 镜像前缀，通过 "<mirror>/<url>" 访问主更新源
+	Mirrors []string
+	// Sources [mirror.<name>] 节定义的镜像，各自有独立的地址
+	Sources []Mirror
+	// MirrorOrder ordered 按配置顺序尝试，weighted 按权重随机排列
+	MirrorOrder string
+	// MirrorCooldown 失败过的镜像在这段时间内排在最后
+	MirrorCooldown time.Duration
+	// Variant 更新包变体（例如 musl、portable），为空时使用默认构建
+	Variant string
+	// Connections 分段下载的并发连接数，1 表示单连接下载
+	Connections int
+	// Retry 网络请求的重试策略
+	Retry RetryPolicy
+	// TLS 证书校验配置
+	TLS TLSConfig
+	// ArchiveLimits 解压更新包时的安全限制
+	ArchiveLimits ArchiveLimits
+	// Wait 安装前等待宿主程序退出
+	Wait WaitConfig
+	// Health 安装后的健康检查，失败时回滚到上一版本
+	Health HealthCheck
+	// Log 日志文件和日志级别
+	Log LogConfig
+}
+
+// DefaultConfig 内置默认配置
+func DefaultConfig() Config {
+	return Config{
+		ManifestURL: VersionURL,
+		ReleaseURL:  ReleaseURL,
+		Weight:      1,
+		Mirrors:     []string{ProxyURL},
+		Connections: DefaultConnections,
+
+		MirrorOrder:    MirrorOrdered,
+		MirrorCooldown: DefaultMirrorCooldown,
+
+		Retry:         DefaultRetryPolicy(),
+		ArchiveLimits: DefaultArchiveLimits(),
+		Wait:          DefaultWaitConfig(),
+		Health:        HealthCheck{Timeout: DefaultHealthTimeout},
+		Log:           DefaultLogConfig(""),
+	}
+}
+
+// LoadConfig 依次应用默认值、dir 下的配置文件和环境变量
+//
+// 命令行参数由调用方在之后覆盖，最终配置在更新开始前通过 Validate 检查。
+func LoadConfig(dir string) (Config, error) {
+	cfg := DefaultConfig()
+	cfg.Log.File = filepath.Join(dir, LogFile)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, ConfigFile))
+	if err == nil {
+		file, err := ini.LoadSources(ini.LoadOptions{ChildSectionDelimiter: ":"}, content)
+		if err != nil {
+			return cfg, fmt.Errorf("无法解析配置文件: %v", err)
+		}
+		r := &configReader{}
+		section := file.Section("")
+		if v := section.Key("manifest_url").String(); v != "" {
+			cfg.ManifestURL = v
+		}
+		if v := section.Key("release_url").String(); v != "" {
+			cfg.ReleaseURL = v
+		}
+		cfg.Weight = r.int(section, "weight", cfg.Weight)
+		if section.HasKey("mirrors") {
+			cfg.Mirrors = splitList(section.Key("mirrors").String())
+		}
+		if v := section.Key("mirror_order").String(); v != "" {
+			cfg.MirrorOrder = strings.ToLower(v)
+		}
+		cfg.MirrorCooldown = r.duration(section, "mirror_cooldown", cfg.MirrorCooldown)
+		for _, s := range file.Sections() {
+			if !strings.HasPrefix(s.Name(), "mirror.") {
+				continue
+			}
+			cfg.Sources = append(cfg.Sources, Mirror{
+				Name:        strings.TrimPrefix(s.Name(), "mirror."),
+				ManifestURL: s.Key("manifest_url").String(),
+				ReleaseURL:  s.Key("release_url").String(),
+				Weight:      r.int(s, "weight", 1),
+			})
+		}
+		if v := section.Key("variant").String(); v != "" {
+			cfg.Variant = v
+		}
+		cfg.Connections = r.int(section, "connections", cfg.Connections)
+
+		retry := file.Section("retry")
+		cfg.Retry.Attempts = r.int(retry, "attempts", cfg.Retry.Attempts)
+		cfg.Retry.InitialDelay = r.duration(retry, "initial_delay", cfg.Retry.InitialDelay)
+		cfg.Retry.MaxDelay = r.duration(retry, "max_delay", cfg.Retry.MaxDelay)
+		cfg.Retry.Multiplier = r.float64(retry, "multiplier", cfg.Retry.Multiplier)
+
+		if v := file.Section("tls").Key("ca_bundle").String(); v != "" {
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(dir, v)
+			}
+			cfg.TLS.CABundle = v
+		}
+		for _, key := range file.Section("pins").Keys() {
+			if cfg.TLS.Pins == nil {
+				cfg.TLS.Pins = make(map[string][]string)
+			}
+			cfg.TLS.Pins[strings.ToLower(key.Name())] = splitList(key.String())
+		}
+
+		archive := file.Section("archive")
+		cfg.ArchiveLimits.MaxEntries = r.int(archive, "max_entries", cfg.ArchiveLimits.MaxEntries)
+		cfg.ArchiveLimits.MaxUnpackedSize = r.int64(archive, "max_unpacked_size", cfg.ArchiveLimits.MaxUnpackedSize)
+		if v := archive.Key("symlinks").String(); v != "" {
+			cfg.ArchiveLimits.SymlinkPolicy = strings.ToLower(v)
+		}
+
+		wait := file.Section("wait")
+		if v := wait.Key("lock_file").String(); v != "" {
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(dir, v)
+			}
+			cfg.Wait.LockFile = v
+		}
+		cfg.Wait.Timeout = r.duration(wait, "timeout", cfg.Wait.Timeout)
+		if v := wait.Key("close").String(); v != "" {
+			cfg.Wait.Close = strings.ToLower(v)
+		}
+		cfg.Wait.CloseAddr = wait.Key("close_addr").String()
+
+		health := file.Section("health")
+		if cfg.Health.Command, err = splitCommand(health.Key("command").String()); err != nil {
+			return cfg, fmt.Errorf("健康检查命令无效: %v", err)
+		}
+		if v := health.Key("marker").String(); v != "" {
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(dir, v)
+			}
+			cfg.Health.Marker = v
+		}
+		cfg.Health.Timeout = r.duration(health, "timeout", cfg.Health.Timeout)
+
+		log := file.Section("log")
+		if v := log.Key("file").String(); v != "" {
+			if v != LogNone && !filepath.IsAbs(v) {
+				v = filepath.Join(dir, v)
+			}
+			cfg.Log.File = v
+		}
+		if v := log.Key("level").String(); v != "" {
+			if cfg.Log.Level, err = ParseLogLevel(v); err != nil {
+				return cfg, err
+			}
+		}
+		cfg.Log.MaxSize = r.int64(log, "max_size", cfg.Log.MaxSize)
+		cfg.Log.MaxFiles = r.int(log, "max_files", cfg.Log.MaxFiles)
+
+		// 与环境变量一样，无效的值是错误而不是悄悄使用默认值
+		if r.err != nil {
+			return cfg, r.err
+		}
+	} else if !os.IsNotExist(err) {
+		return cfg, fmt.Errorf("无法读取配置文件: %v", err)
+	}
+
+	if v := os.Getenv(EnvManifestURL); v != "" {
+		cfg.ManifestURL = v
+	}
+	if v := os.Getenv(EnvReleaseURL); v != "" {
+		cfg.ReleaseURL = v
+	}
+	if v, ok := os.LookupEnv(EnvMirrors); ok {
+		cfg.Mirrors = splitList(v)
+	}
+	if v := os.Getenv(EnvVariant); v != "" {
+		cfg.Variant = v
+	}
+	if v := os.Getenv(EnvConnections); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("无效的连接数 %s=%q", EnvConnections, v)
+		}
+		cfg.Connections = n
+	}
+
+	return cfg, nil
+}
+
+// Validate 检查配置是否可用
+func (c Config) Validate() error {
+	names := make(map[string]bool)
+	for _, m := range c.MirrorList() {
+		if names[m.Name] {
+			return fmt.Errorf("更新源名称重复: %s", m.Name)
+		}
+		names[m.Name] = true
+		if _, err := url.Parse(m.ManifestURL); err != nil || m.ManifestURL == "" {
+			return fmt.Errorf("更新源 %s: 无效的版本信息地址: %q", m.Name, m.ManifestURL)
+		}
+		if strings.Count(m.ReleaseURL, "%s") != 2 {
+			return fmt.Errorf("更新源 %s: 更新包地址模板需要包含两个 %%s (版本号、文件名): %q", m.Name, m.ReleaseURL)
+		}
+	}
+	switch c.MirrorOrder {
+	case MirrorOrdered, MirrorWeighted:
+	default:
+		return fmt.Errorf("未知的镜像顺序: %q", c.MirrorOrder)
+	}
+	if c.Connections < 1 || c.Connections > MaxConnections {
+		return fmt.Errorf("连接数需要在 1 到 %d 之间: %d", MaxConnections, c.Connections)
+	}
+	if err := c.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+	if err := c.Wait.Validate(); err != nil {
+		return err
+	}
+	if err := c.Health.Validate(); err != nil {
+		return err
+	}
+	if err := c.Log.Validate(); err != nil {
+		return err
+	}
+	switch c.ArchiveLimits.SymlinkPolicy {
+	case SymlinkReject, SymlinkSkip, SymlinkAllow:
+	default:
+		return fmt.Errorf("未知的符号链接策略: %q", c.ArchiveLimits.SymlinkPolicy)
+	}
+	return nil
+}
+
+// MirrorList 所有更新源: 主更新源、[mirror.<name>] 定义的镜像、通过镜像前缀访问的主更新源
+func (c Config) MirrorList() []Mirror {
+	mirrors := []Mirror{{
+		Name:        DefaultMirrorName,
+		ManifestURL: c.ManifestURL,
+		ReleaseURL:  c.ReleaseURL,
+		Weight:      c.Weight,
+	}}
+	mirrors = append(mirrors, c.Sources...)
+	for _, prefix := range c.Mirrors {
+		mirrors = append(mirrors, Mirror{
+			Name:        prefix,
+			ManifestURL: prefix + "/" + c.ManifestURL,
+			ReleaseURL:  prefix + "/" + c.ReleaseURL,
+			Weight:      1,
+		})
+	}
+	return mirrors
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, strings.TrimSuffix(item, "/"))
+		}
+	}
+	return items
+}
+
+// configReader 读取配置文件中的数值，键不存在或为空时使用默认值，记录第一个无效的值
+type configReader struct {
+	err error
+}
+
+func (r *configReader) value(section *ini.Section, name string) (string, bool) {
+	v := strings.TrimSpace(section.Key(name).String())
+	return v, v != "" && r.err == nil
+}
+
+func (r *configReader) invalid(section *ini.Section, name string, value string) {
+	if section.Name() != ini.DefaultSection {
+		name = fmt.Sprintf("[%s] %s", section.Name(), name)
+	}
+	r.err = fmt.Errorf("配置文件中的值无效 %s=%q", name, value)
+}
+
+func (r *configReader) int(section *ini.Section, name string, def int) int {
+	v, ok := r.value(section, name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		r.invalid(section, name, v)
+		return def
+	}
+	return n
+}
+
+func (r *configReader) int64(section *ini.Section, name string, def int64) int64 {
+	v, ok := r.value(section, name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		r.invalid(section, name, v)
+		return def
+	}
+	return n
+}
+
+func (r *configReader) float64(section *ini.Section, name string, def float64) float64 {
+	v, ok := r.value(section, name)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		r.invalid(section, name, v)
+		return def
+	}
+	return f
+}
+
+// duration 读取 "30s"、"10m" 形式的时间
+func (r *configReader) duration(section *ini.Section, name string, def time.Duration) time.Duration {
+	v, ok := r.value(section, name)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		r.invalid(section, name, v)
+		return def
+	}
+	return d
+}
+
+// splitCommand 按空白分隔命令行，单引号或双引号中的空白不分隔参数
+//
+// 反斜杠没有特殊含义，Windows 路径可以直接写入。
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("引号没有闭合: %s", s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
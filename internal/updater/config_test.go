@@ -0,0 +1,99 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadConfigValues(t *testing.T) {
+	dir := writeTestConfig(t, "mirror_cooldown = 30s\nconnections = 2\nweight =\n\n[retry]\nattempts = 6\nmultiplier = 1.5\n\n[wait]\ntimeout = 2m\n")
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MirrorCooldown != 30*time.Second {
+		t.Errorf("MirrorCooldown = %v, want 30s", cfg.MirrorCooldown)
+	}
+	if cfg.Connections != 2 || cfg.Weight != 1 {
+		t.Errorf("Connections = %d, Weight = %d, want 2, 1", cfg.Connections, cfg.Weight)
+	}
+	if cfg.Retry.Attempts != 6 || cfg.Retry.Multiplier != 1.5 {
+		t.Errorf("Retry = %+v", cfg.Retry)
+	}
+	if cfg.Wait.Timeout != 2*time.Minute {
+		t.Errorf("Wait.Timeout = %v, want 2m", cfg.Wait.Timeout)
+	}
+}
+
+func TestLoadConfigInvalidValues(t *testing.T) {
+	tests := []struct {
+		content string
+		key     string
+	}{
+		{"connections = four\n", "connections"},
+		{"mirror_cooldown = 600\n", "mirror_cooldown"},
+		{"[retry]\ninitial_delay = soon\n", "[retry] initial_delay"},
+		{"[retry]\nmultiplier = x2\n", "[retry] multiplier"},
+		{"[archive]\nmax_unpacked_size = 4GB\n", "[archive] max_unpacked_size"},
+		{"[wait]\ntimeout = 60\n", "[wait] timeout"},
+		{"[mirror.cdn]\nweight = heavy\n", "[mirror.cdn] weight"},
+		{"[log]\nmax_files = -\n", "[log] max_files"},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfig(writeTestConfig(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.key+"=") {
+			t.Errorf("LoadConfig(%q) = %v, want error for %s", tt.content, err, tt.key)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"./app --self-test", []string{"./app", "--self-test"}},
+		{"  ./app \t-v  ", []string{"./app", "-v"}},
+		{`"./My App" --self-test`, []string{"./My App", "--self-test"}},
+		{`./app --name='a b' ""`, []string{"./app", "--name=a b", ""}},
+		{`C:\Program\app.exe "it's"`, []string{`C:\Program\app.exe`, "it's"}},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	if _, err := splitCommand(`./app "unterminated`); err == nil {
+		t.Error("引号没有闭合时 splitCommand() 没有返回错误")
+	}
+}
+
+func TestLoadConfigHealthCommand(t *testing.T) {
+	dir := writeTestConfig(t, "[health]\ncommand = \"./My App\" --self-test\n")
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"./My App", "--self-test"}; !reflect.DeepEqual(cfg.Health.Command, want) {
+		t.Fatalf("Health.Command = %q, want %q", cfg.Health.Command, want)
+	}
+}
@@ -31,9 +31,9 @@ func init() {
 	gocoa.InitApplication()
 }
 
-func NewMainWindow() (*ProgressWindow, error) {
+func NewMainWindow(title string) (*ProgressWindow, error) {
 
-	wnd := gocoa.NewCenteredWindow(AppName, WindowWidth, WindowHeight)
+	wnd := gocoa.NewCenteredWindow(title, WindowWidth, WindowHeight)
 
 	progressBar := gocoa.NewProgressIndicator(12, 20, 440, 24)
 	logTextView := gocoa.NewTextView(12, 100, 440, 180)
@@ -56,10 +56,10 @@ func NewMainWindow() (*ProgressWindow, error) {
 	}, nil
 }
 
-func ShowMainWindow() {
+func ShowMainWindow(title string) {
 	if MainWindow == nil {
 		var err error
-		MainWindow, err = NewMainWindow()
+		MainWindow, err = NewMainWindow(title)
 		if err != nil {
 			return
 		}
@@ -92,28 +92,28 @@ func SetUpdateComplete() {
 	}
 }
 
-func ShowUpdateErrorDialog(message string) {
+func ShowUpdateErrorDialog(title, message string) {
 	AppendLogText("Update Error: " + message)
-	ShowMessageBox(AppName, message, 1)
+	ShowMessageBox(title, message, 1)
 }
 
-func ShowUpdateConfirmDialog(message string) bool {
+func ShowUpdateConfirmDialog(title, message string) bool {
 
-	return ShowMessageBox(AppName, message, 2) != 0
+	return ShowMessageBox(title, message, 2) != 0
 }
 
 func ShowMessageBox(title, message string, uType uint) int32 {
 	switch uType {
 	case 1:
-		dialog.Message("%s", message).Title(AppName).Error()
+		dialog.Message("%s", message).Title(title).Error()
 	case 2:
-		if dialog.Message("%s", message).Title(AppName).YesNo() {
+		if dialog.Message("%s", message).Title(title).YesNo() {
 			return 1
 		} else {
 			return 0 // IDNO
 		}
 	default:
-		dialog.Message("%s", message).Title(AppName).Info()
+		dialog.Message("%s", message).Title(title).Info()
 	}
 
 	return 0
@@ -126,3 +126,27 @@ func IsUpdateCancelled() bool {
 func AppLoop() {
 	gocoa.RunApplication()
 }
+
+// windowUI 基于 Cocoa 窗口的界面后端，title 为窗口和对话框的标题
+type windowUI struct {
+	title string
+}
+
+func newPlatformUI(appName string) UI {
+	return windowUI{title: appName}
+}
+
+func (w windowUI) ShowMainWindow()                      { ShowMainWindow(w.title) }
+func (windowUI) AppendLogText(text string)              { AppendLogText(text) }
+func (windowUI) SetUpdateProgress(progress float64)     { SetUpdateProgress(progress) }
+func (windowUI) SetUpdateComplete()                     { SetUpdateComplete() }
+func (w windowUI) ShowUpdateErrorDialog(message string) { ShowUpdateErrorDialog(w.title, message) }
+func (w windowUI) ShowUpdateConfirmDialog(message string) bool {
+	return ShowUpdateConfirmDialog(w.title, message)
+}
+func (windowUI) CloseWindow()            { CloseWindow() }
+func (windowUI) IsUpdateCancelled() bool { return IsUpdateCancelled() }
+func (windowUI) AppLoop()                { AppLoop() }
+
+// UpdateFinished 窗口由用户关闭，这里无需处理
+func (windowUI) UpdateFinished() {}
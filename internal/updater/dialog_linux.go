@@ -0,0 +1,213 @@
+//go:build linux
+// +build linux
+
+package updater
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	ProgressBarWidth = 40
+)
+
+// terminalUI 基于终端的界面后端，输出文本进度条与日志，通过 y/n 提示确认
+type terminalUI struct {
+	// title 应用名称，显示在输出的第一行
+	title string
+
+	mu  sync.Mutex
+	out io.Writer
+	in  *bufio.Reader
+	tty bool
+
+	// 上次绘制的进度百分比，-1 表示当前没有进度条
+	percent int
+
+	// pending 正在后台读取的一行输入，确认被取消后留给下一次确认
+	pending chan inputLine
+
+	isUpdateCancelled uint32
+	quit              chan struct{}
+	quitOnce          sync.Once
+}
+
+func newPlatformUI(appName string) UI {
+	t := newTerminalUI(os.Stderr, os.Stdin)
+	t.title = appName
+	return t
+}
+
+func newTerminalUI(out *os.File, in io.Reader) *terminalUI {
+	tty := false
+	if fi, err := out.Stat(); err == nil {
+		tty = fi.Mode()&os.ModeCharDevice != 0
+	}
+
+	return &terminalUI{
+		out:     out,
+		in:      bufio.NewReader(in),
+		tty:     tty,
+		percent: -1,
+		quit:    make(chan struct{}),
+	}
+}
+
+func (t *terminalUI) ShowMainWindow() {
+	t.AppendLogText(t.title)
+}
+
+func (t *terminalUI) AppendLogText(text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clearProgressLocked()
+	fmt.Fprintln(t.out, text)
+	if t.tty {
+		t.drawProgressLocked()
+	}
+}
+
+func (t *terminalUI) SetUpdateProgress(progress float64) {
+	percent := int(progress * 100)
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if percent == t.percent {
+		return
+	}
+
+	// 非终端输出（日志文件、CI）时每 10% 输出一行
+	if !t.tty && t.percent >= 0 && percent/10 == t.percent/10 && percent != 100 {
+		return
+	}
+
+	t.percent = percent
+	t.drawProgressLocked()
+}
+
+func (t *terminalUI) SetUpdateComplete() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.percent >= 0 && t.tty {
+		fmt.Fprintln(t.out)
+	}
+	t.percent = -1
+}
+
+func (t *terminalUI) ShowUpdateErrorDialog(message string) {
+	t.AppendLogText("Update Error: " + message)
+}
+
+// inputLine 后台读取的一行输入
+type inputLine struct {
+	text string
+	err  error
+}
+
+func (t *terminalUI) ShowUpdateConfirmDialog(message string) bool {
+	return t.ShowUpdateConfirmDialogContext(context.Background(), message)
+}
+
+// ShowUpdateConfirmDialogContext 等待 y/n 输入，ctx 取消时按拒绝处理
+//
+// 只在输出提示时持有 t.mu，等待输入期间日志仍然可以输出。
+func (t *terminalUI) ShowUpdateConfirmDialogContext(ctx context.Context, message string) bool {
+	t.mu.Lock()
+	t.clearProgressLocked()
+	fmt.Fprintf(t.out, "%s [y/N]: ", message)
+	if t.pending == nil {
+		t.pending = make(chan inputLine, 1)
+		go func(pending chan<- inputLine) {
+			text, err := t.in.ReadString('\n')
+			pending <- inputLine{text: text, err: err}
+		}(t.pending)
+	}
+	pending := t.pending
+	t.mu.Unlock()
+
+	var line inputLine
+	select {
+	case line = <-pending:
+		t.mu.Lock()
+		t.pending = nil
+		t.mu.Unlock()
+	case <-ctx.Done():
+		t.mu.Lock()
+		fmt.Fprintln(t.out)
+		t.mu.Unlock()
+		return false
+	}
+
+	if line.err != nil && line.text == "" {
+		// 没有可用的输入（例如在容器中运行），按拒绝处理
+		t.mu.Lock()
+		fmt.Fprintln(t.out)
+		t.mu.Unlock()
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line.text)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *terminalUI) CloseWindow() {
+	t.UpdateFinished()
+}
+
+func (t *terminalUI) IsUpdateCancelled() bool {
+	return atomic.LoadUint32(&t.isUpdateCancelled) != 0
+}
+
+func (t *terminalUI) AppLoop() {
+	<-t.quit
+}
+
+func (t *terminalUI) UpdateFinished() {
+	t.quitOnce.Do(func() {
+		t.SetUpdateComplete()
+		close(t.quit)
+	})
+}
+
+// drawProgressLocked 绘制进度条，调用方需持有 t.mu
+func (t *terminalUI) drawProgressLocked() {
+	if t.percent < 0 {
+		return
+	}
+
+	filled := t.percent * ProgressBarWidth / 100
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", ProgressBarWidth-filled)
+
+	if t.tty {
+		fmt.Fprintf(t.out, "\r[%s] %3d%%", bar, t.percent)
+	} else {
+		fmt.Fprintf(t.out, "[%s] %3d%%\n", bar, t.percent)
+	}
+}
+
+// clearProgressLocked 擦除当前行的进度条，调用方需持有 t.mu
+func (t *terminalUI) clearProgressLocked() {
+	if t.percent < 0 || !t.tty {
+		return
+	}
+	fmt.Fprintf(t.out, "\r%s\r", strings.Repeat(" ", ProgressBarWidth+7))
+}
@@ -49,10 +49,10 @@ func TCHAR(s string) *uint16 {
 	return utf16
 }
 
-func NewMainWindow() (*ProgressWindow, error) {
+func NewMainWindow(title string) (*ProgressWindow, error) {
 
 	className := TCHAR("ProgressWindowClass")
-	windowName := TCHAR(AppName)
+	windowName := TCHAR(title)
 
 	screenWidth := w32.GetSystemMetrics(w32.SM_CXSCREEN)
 	screenHeight := w32.GetSystemMetrics(w32.SM_CYSCREEN)
@@ -176,10 +176,10 @@ func AppLoop() {
 
 }
 
-func ShowMainWindow() {
+func ShowMainWindow(title string) {
 	if MainWindow == nil {
 		var err error
-		MainWindow, err = NewMainWindow()
+		MainWindow, err = NewMainWindow(title)
 		if err != nil {
 			return
 		}
@@ -231,3 +231,25 @@ func ShowMessageBox(title, message string, uType uint) int32 {
 func IsUpdateCancelled() bool {
 	return isUpdateCancelled
 }
+
+// windowUI 基于 Win32 窗口的界面后端，title 为窗口标题
+type windowUI struct {
+	title string
+}
+
+func newPlatformUI(appName string) UI {
+	return windowUI{title: appName}
+}
+
+func (w windowUI) ShowMainWindow()                           { ShowMainWindow(w.title) }
+func (windowUI) AppendLogText(text string)                   { AppendLogText(text) }
+func (windowUI) SetUpdateProgress(progress float64)          { SetUpdateProgress(progress) }
+func (windowUI) SetUpdateComplete()                          { SetUpdateComplete() }
+func (windowUI) ShowUpdateErrorDialog(message string)        { ShowUpdateErrorDialog(message) }
+func (windowUI) ShowUpdateConfirmDialog(message string) bool { return ShowUpdateConfirmDialog(message) }
+func (windowUI) CloseWindow()                                { CloseWindow() }
+func (windowUI) IsUpdateCancelled() bool                     { return IsUpdateCancelled() }
+func (windowUI) AppLoop()                                    { AppLoop() }
+
+// UpdateFinished 窗口由用户关闭，这里无需处理
+func (windowUI) UpdateFinished() {}
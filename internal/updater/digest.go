@@ -0,0 +1,114 @@
+package updater
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	DigestMD5    = "md5"
+	DigestSHA256 = "sha256"
+	DigestSHA512 = "sha512"
+)
+
+// digestAlgorithms 按强度从高到低排列
+var digestAlgorithms = []string{DigestSHA512, DigestSHA256, DigestMD5}
+
+// Digest 带算法标记的摘要
+type Digest struct {
+	Algorithm string
+	Value     string
+}
+
+func (d Digest) String() string {
+	return d.Algorithm + ":" + d.Value
+}
+
+func (d Digest) newHash() hash.Hash {
+	switch d.Algorithm {
+	case DigestSHA512:
+		return sha512.New()
+	case DigestSHA256:
+		return sha256.New()
+	case DigestMD5:
+		return md5.New()
+	}
+	return nil
+}
+
+// Verify 比较计算得到的摘要与期望值
+func (d Digest) Verify(h hash.Hash) error {
+	actual := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(actual, d.Value) {
+		return fmt.Errorf("%s 不匹配: 期望 %s, 实际 %s", d.Algorithm, d.Value, actual)
+	}
+	return nil
+}
+
+// parseDigests 读取按算法列出的摘要以及 "<算法>:<hex>" 形式（逗号分隔）的摘要
+func parseDigests(values map[string]string, tagged string) (map[string]string, error) {
+	digests := make(map[string]string)
+
+	for algo, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			digests[strings.ToLower(algo)] = strings.ToLower(value)
+		}
+	}
+
+	for _, item := range strings.Split(tagged, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("无法解析摘要 %q", item)
+		}
+		algo := strings.ToLower(strings.TrimSpace(parts[0]))
+		digests[algo] = strings.ToLower(strings.TrimSpace(parts[1]))
+	}
+
+	for algo, value := range digests {
+		d := Digest{Algorithm: algo, Value: value}
+		h := d.newHash()
+		if h == nil {
+			return nil, fmt.Errorf("不支持的摘要算法: %s", algo)
+		}
+		if _, err := hex.DecodeString(value); err != nil || len(value) != h.Size()*2 {
+			return nil, fmt.Errorf("摘要格式错误: %s", d)
+		}
+	}
+
+	return digests, nil
+}
+
+// parseSectionDigests 读取 md5=、sha256=、sha512= 以及 digest=<算法>:<hex> 形式的摘要
+func parseSectionDigests(section *ini.Section) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, algo := range digestAlgorithms {
+		values[algo] = section.Key(algo).String()
+	}
+	return parseDigests(values, section.Key("digest").String())
+}
+
+// strongestDigest 选取最强的摘要，只有 MD5 时需要显式允许
+func strongestDigest(digests map[string]string, allowMD5 bool) (Digest, error) {
+	for _, algo := range digestAlgorithms {
+		value, ok := digests[algo]
+		if !ok {
+			continue
+		}
+		if algo == DigestMD5 && !allowMD5 {
+			return Digest{}, fmt.Errorf("版本信息只提供了 MD5 摘要，需要显式允许 (-allow-md5)")
+		}
+		return Digest{Algorithm: algo, Value: value}, nil
+	}
+	return Digest{}, fmt.Errorf("版本信息缺少摘要")
+}
@@ -0,0 +1,84 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+var (
+	testMD5    = strings.Repeat("a", 32)
+	testSHA256 = strings.Repeat("b", 64)
+	testSHA512 = strings.Repeat("c", 128)
+)
+
+func TestStrongestDigest(t *testing.T) {
+	tests := []struct {
+		name     string
+		digests  map[string]string
+		allowMD5 bool
+		want     Digest
+		wantErr  bool
+	}{
+		{"全部", map[string]string{DigestMD5: testMD5, DigestSHA256: testSHA256, DigestSHA512: testSHA512}, false, Digest{DigestSHA512, testSHA512}, false},
+		{"SHA-256 与 MD5", map[string]string{DigestMD5: testMD5, DigestSHA256: testSHA256}, false, Digest{DigestSHA256, testSHA256}, false},
+		{"只有 SHA-256", map[string]string{DigestSHA256: testSHA256}, false, Digest{DigestSHA256, testSHA256}, false},
+		{"只有 MD5，未允许", map[string]string{DigestMD5: testMD5}, false, Digest{}, true},
+		{"只有 MD5，已允许", map[string]string{DigestMD5: testMD5}, true, Digest{DigestMD5, testMD5}, false},
+		{"允许 MD5 时仍选择更强的摘要", map[string]string{DigestMD5: testMD5, DigestSHA512: testSHA512}, true, Digest{DigestSHA512, testSHA512}, false},
+		{"没有摘要", map[string]string{}, true, Digest{}, true},
+		{"nil", nil, false, Digest{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := strongestDigest(tt.digests, tt.allowMD5)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("strongestDigest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("strongestDigest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDigests(t *testing.T) {
+	got, err := parseDigests(map[string]string{"SHA256": " " + strings.ToUpper(testSHA256) + " ", DigestMD5: ""},
+		"sha512:"+testSHA512+", md5:"+testMD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{DigestSHA256: testSHA256, DigestSHA512: testSHA512, DigestMD5: testMD5}
+	if len(got) != len(want) {
+		t.Fatalf("parseDigests() = %v, want %v", got, want)
+	}
+	for algo, value := range want {
+		if got[algo] != value {
+			t.Errorf("parseDigests()[%s] = %q, want %q", algo, got[algo], value)
+		}
+	}
+
+	for _, tagged := range []string{
+		"sha1:" + strings.Repeat("d", 40),
+		"sha256:" + testSHA256[:62],
+		"sha256:" + strings.Repeat("z", 64),
+		"sha256",
+	} {
+		if _, err := parseDigests(nil, tagged); err == nil {
+			t.Errorf("parseDigests(%q) 没有返回错误", tagged)
+		}
+	}
+}
+
+func TestDigestVerify(t *testing.T) {
+	h := sha256.New()
+	h.Write([]byte("hello"))
+	sum := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	if err := (Digest{DigestSHA256, strings.ToUpper(sum)}).Verify(h); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if err := (Digest{DigestSHA256, testSHA256}).Verify(h); err == nil {
+		t.Error("Verify() 没有发现摘要不匹配")
+	}
+}
@@ -0,0 +1,604 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	DefaultConnections = 4
+	MaxConnections     = 16
+	PartsFileSuffix    = ".parts"
+)
+
+var (
+	// errRangeUnsupported 服务器忽略了 Range 请求，需要退回单连接下载
+	errRangeUnsupported = errors.New("服务器不支持分段下载")
+	// errRemoteChanged 续传过程中服务器上的文件发生了变化，已下载的部分不能再使用
+	errRemoteChanged = errors.New("服务器上的文件已变化")
+)
+
+// downloadState 未完成下载的记录，保存在下载文件旁的 .parts 文件中
+//
+//	version       = 1.2.0
+//	digest        = sha256:...
+//	mirror        = default
+//	size          = 3500172
+//	etag          = "5f3a-1b2c"
+//	last_modified = Mon, 02 Jan 2006 15:04:05 GMT
+//	chunk_size    = 1048576
+//	done          = 0,1,3
+//
+// version 与 digest 标识下载的是哪个更新包，与本次更新不一致时已下载的部分直接丢弃；
+// size、etag、last_modified 用于确认服务器上的文件没有变化，其中 etag 与 last_modified
+// 只对记录它们的镜像 mirror 有效，换到其他镜像续传时只比较大小，最终由摘要保证内容正确；
+// chunk_size 为 0 表示单连接下载，done 为分段下载中已完成的分段。
+type downloadState struct {
+	path string
+	// filename 更新包或补丁在服务器上的文件名，不写入 .parts 文件
+	filename string
+
+	Version      string
+	Digest       string
+	Mirror       string
+	Size         int64
+	ETag         string
+	LastModified string
+	ChunkSize    int64
+
+	mu   sync.Mutex
+	done map[int]bool
+}
+
+// loadDownloadState 读取 .parts 文件，文件不存在或无法解析时返回空记录
+func loadDownloadState(path string) *downloadState {
+	s := &downloadState{path: path, done: make(map[int]bool)}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return s
+	}
+	cfg, err := ini.Load(content)
+	if err != nil {
+		return s
+	}
+
+	section := cfg.Section("")
+	s.Version = section.Key("version").String()
+	s.Digest = section.Key("digest").String()
+	s.Mirror = section.Key("mirror").String()
+	s.Size = section.Key("size").MustInt64(0)
+	s.ETag = section.Key("etag").String()
+	s.LastModified = section.Key("last_modified").String()
+	s.ChunkSize = section.Key("chunk_size").MustInt64(0)
+	if s.ChunkSize > 0 {
+		for _, item := range strings.Split(section.Key("done").String(), ",") {
+			if i, err := strconv.Atoi(strings.TrimSpace(item)); err == nil && i >= 0 && i < s.chunks() {
+				s.done[i] = true
+			}
+		}
+	}
+	return s
+}
+
+// reset 清空记录，用于开始一次新的下载
+func (s *downloadState) reset(version string, digest string) {
+	s.Version, s.Digest = version, digest
+	s.Mirror, s.Size, s.ETag, s.LastModified, s.ChunkSize = "", 0, "", "", 0
+	s.done = make(map[int]bool)
+}
+
+// setRemote 记录镜像返回的文件大小和校验信息
+func (s *downloadState) setRemote(mirror string, size int64, header http.Header) {
+	s.Mirror = mirror
+	s.Size = size
+	s.ETag = header.Get("ETag")
+	s.LastModified = header.Get("Last-Modified")
+}
+
+// sameRemote 检查镜像上的文件是否与记录一致
+func (s *downloadState) sameRemote(mirror string, size int64, header http.Header) bool {
+	if size != s.Size {
+		return false
+	}
+	if mirror != s.Mirror {
+		return true
+	}
+	if etag := header.Get("ETag"); etag != "" && s.ETag != "" && etag != s.ETag {
+		return false
+	}
+	if lm := header.Get("Last-Modified"); lm != "" && s.LastModified != "" && lm != s.LastModified {
+		return false
+	}
+	return true
+}
+
+// validator If-Range 使用的值，弱 ETag 不能用于 If-Range，此时使用 Last-Modified
+func (s *downloadState) validator(mirror string) string {
+	if mirror != s.Mirror {
+		return ""
+	}
+	if s.ETag != "" && !strings.HasPrefix(s.ETag, "W/") {
+		return s.ETag
+	}
+	return s.LastModified
+}
+
+func (s *downloadState) chunks() int {
+	return int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+}
+
+func (s *downloadState) bounds(i int) (start, end int64) {
+	start = int64(i) * s.ChunkSize
+	end = start + s.ChunkSize - 1
+	if end >= s.Size {
+		end = s.Size - 1
+	}
+	return start, end
+}
+
+func (s *downloadState) doneBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for i := range s.done {
+		start, end := s.bounds(i)
+		n += end - start + 1
+	}
+	return n
+}
+
+func (s *downloadState) isDone(i int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.done[i]
+}
+
+// markDone 记录一个完成的分段并立即写入 .parts 文件
+func (s *downloadState) markDone(i int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done[i] = true
+	return s.saveLocked()
+}
+
+func (s *downloadState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saveLocked()
+}
+
+func (s *downloadState) saveLocked() error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "version=%s\ndigest=%s\nmirror=%s\nsize=%d\n", s.Version, s.Digest, s.Mirror, s.Size)
+	if s.ETag != "" {
+		// ETag 通常带引号，使用 ini 的反引号避免被解析掉
+		fmt.Fprintf(&b, "etag=`%s`\n", s.ETag)
+	}
+	if s.LastModified != "" {
+		fmt.Fprintf(&b, "last_modified=%s\n", s.LastModified)
+	}
+	if s.ChunkSize > 0 {
+		var done []int
+		for i := range s.done {
+			done = append(done, i)
+		}
+		sort.Ints(done)
+		items := make([]string, len(done))
+		for i, n := range done {
+			items[i] = strconv.Itoa(n)
+		}
+		fmt.Fprintf(&b, "chunk_size=%d\ndone=%s\n", s.ChunkSize, strings.Join(items, ","))
+	}
+	return ioutil.WriteFile(s.path, []byte(b.String()), 0644)
+}
+
+// downloadTarget 要下载的文件: 完整更新包或补丁
+type downloadTarget struct {
+	Filename string
+	Size     int64
+	Digest   Digest
+}
+
+// discardPartial 删除未完成的下载及其记录
+func discardPartial(filePath string) {
+	os.Remove(filePath + PartsFileSuffix)
+	os.Remove(filePath)
+}
+
+// download 下载更新包或补丁并把内容写入 hash
+//
+// 连接数大于 1 时按 ChunkSize 分段并发下载；服务器不支持 Range 时退回单连接断点续传。
+// 已下载的部分属于其他版本或摘要时直接丢弃；续传时服务器上的文件发生变化则重新下载一次。
+// 请求失败时换到下一个镜像，已下载的部分继续使用。
+func (u *Updater) download(ctx context.Context, target downloadTarget, filePath string, hash hash.Hash) error {
+	start := time.Now()
+	digest := target.Digest
+	state := loadDownloadState(filePath + PartsFileSuffix)
+	state.filename = target.Filename
+	if state.Version != u.NewVer.Version || state.Digest != digest.String() {
+		if _, err := os.Stat(filePath); err == nil {
+			u.log.Info("丢弃不属于本次更新的未完成下载", "file", target.Filename)
+		}
+		discardPartial(filePath)
+		state.reset(u.NewVer.Version, digest.String())
+	}
+
+	err := u.downloadOnce(ctx, filePath, state, hash)
+	if errors.Is(err, errRemoteChanged) {
+		u.log.Warn("服务器上的文件已变化，重新下载", "file", target.Filename)
+		discardPartial(filePath)
+		state.reset(u.NewVer.Version, digest.String())
+		hash.Reset()
+		err = u.downloadOnce(ctx, filePath, state, hash)
+	}
+	if err != nil {
+		return err
+	}
+
+	if target.Size > 0 && state.Size != target.Size {
+		discardPartial(filePath)
+		return fmt.Errorf("文件大小不符: 期望 %d, 实际 %d", target.Size, state.Size)
+	}
+	os.Remove(state.path)
+	u.log.Info(fmt.Sprintf("下载完成: %s", target.Filename), "file", target.Filename, "bytes", state.Size, "duration", time.Since(start))
+	return nil
+}
+
+func (u *Updater) downloadOnce(ctx context.Context, filePath string, state *downloadState, hash hash.Hash) error {
+	if u.Config.Connections > 1 {
+		err := u.downloadChunked(ctx, filePath, state, hash)
+		if !errors.Is(err, errRangeUnsupported) {
+			return err
+		}
+
+		u.log.Info("服务器不支持分段下载，使用单连接下载")
+		discardPartial(filePath)
+		state.reset(state.Version, state.Digest)
+		hash.Reset()
+	}
+
+	return u.tryMirrors(ctx, func(m Mirror) error {
+		return u.downloadWithResume(ctx, m, filePath, state, hash)
+	}, func(err error) bool {
+		return errors.Is(err, errRemoteChanged)
+	})
+}
+
+// probeSize 通过 Range 请求获取文件大小和响应头，服务器忽略 Range 时返回 errRangeUnsupported
+func (u *Updater) probeSize(ctx context.Context, url string) (int64, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := u.getHTTPClient().Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return 0, nil, errRangeUnsupported
+	default:
+		return 0, nil, newHTTPStatusError(url, resp)
+	}
+
+	size := contentRangeSize(resp.Header.Get("Content-Range"))
+	if size <= 0 {
+		return 0, nil, errRangeUnsupported
+	}
+	return size, resp.Header, nil
+}
+
+// contentRangeSize 从 "bytes 0-0/12345" 中取出文件总大小，无法解析时返回 -1
+func contentRangeSize(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// downloadChunked 分段并发下载，每个分段完成后记录在 .parts 文件中，中断后只重新下载未完成的分段
+//
+// 文件内容按顺序写入 hash，见 chunkHasher。
+func (u *Updater) downloadChunked(ctx context.Context, filePath string, state *downloadState, hash hash.Hash) error {
+	var mirror Mirror
+	var size int64
+	var header http.Header
+	err := u.tryMirrors(ctx, func(m Mirror) error {
+		var err error
+		mirror = m
+		size, header, err = u.probeSize(ctx, m.packageURL(u.NewVer.Version, state.filename))
+		return err
+	}, func(err error) bool {
+		return errors.Is(err, errRangeUnsupported)
+	})
+	if err != nil {
+		return err
+	}
+	url := mirror.packageURL(u.NewVer.Version, state.filename)
+	u.log.Debug(fmt.Sprintf("分段下载: %s", url), "url", url, "mirror", mirror.Name, "bytes", size, "connections", u.Config.Connections)
+
+	if state.ChunkSize != ChunkSize || !state.sameRemote(mirror.Name, size, header) {
+		// 没有可用的分段记录，之前的文件内容不可信
+		os.Remove(filePath)
+		state.reset(state.Version, state.Digest)
+		state.ChunkSize = ChunkSize
+		state.setRemote(mirror.Name, size, header)
+		if err := state.save(); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("分配文件空间失败: %v", err)
+	}
+
+	var downloaded int64 = state.doneBytes()
+	u.SetProgress(downloadProgress(downloaded, size))
+
+	var pending []int
+	for i := 0; i < state.chunks(); i++ {
+		if !state.done[i] {
+			pending = append(pending, i)
+		}
+	}
+
+	hasher := startChunkHasher(file, state, hash)
+
+	jobs := make(chan int)
+	errs := make(chan error, u.Config.Connections)
+	var wg sync.WaitGroup
+	var failed int32
+
+	for w := 0; w < u.Config.Connections; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				err := u.downloadChunk(ctx, file, state, i, &downloaded)
+				if err == nil {
+					err = state.markDone(i)
+				}
+				if err == nil {
+					hasher.chunkDone()
+				} else {
+					atomic.StoreInt32(&failed, 1)
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	for _, i := range pending {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	hashErr := hasher.wait()
+	if err := <-errs; err != nil {
+		return err
+	}
+	if hashErr != nil {
+		return fmt.Errorf("计算摘要失败: %v", hashErr)
+	}
+
+	return file.Sync()
+}
+
+// chunkHasher 在后台按顺序把已完成的分段写入 hash
+//
+// 分段乱序完成，某个分段之前的分段全部完成后才从文件中读回并计算摘要，
+// 下载结束时通常只剩最后完成的几个分段需要计算，不必再读一遍整个文件。
+type chunkHasher struct {
+	file  *os.File
+	state *downloadState
+	hash  hash.Hash
+	// next 下一个要计算的分段
+	next int
+
+	notify chan struct{}
+	done   chan error
+}
+
+func startChunkHasher(file *os.File, state *downloadState, h hash.Hash) *chunkHasher {
+	c := &chunkHasher{
+		file:   file,
+		state:  state,
+		hash:   h,
+		notify: make(chan struct{}, 1),
+		done:   make(chan error, 1),
+	}
+	// 续传时之前完成的分段立即开始计算
+	c.chunkDone()
+	go c.run()
+	return c
+}
+
+// chunkDone 通知有分段完成，不会阻塞下载
+func (c *chunkHasher) chunkDone() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// wait 在所有下载结束后调用，计算剩余的分段
+func (c *chunkHasher) wait() error {
+	close(c.notify)
+	return <-c.done
+}
+
+func (c *chunkHasher) run() {
+	for range c.notify {
+		if err := c.advance(); err != nil {
+			c.done <- err
+			return
+		}
+	}
+	c.done <- c.advance()
+}
+
+func (c *chunkHasher) advance() error {
+	for c.next < c.state.chunks() && c.state.isDone(c.next) {
+		start, end := c.state.bounds(c.next)
+		if _, err := io.Copy(c.hash, io.NewSectionReader(c.file, start, end-start+1)); err != nil {
+			return err
+		}
+		c.next++
+	}
+	return nil
+}
+
+// downloadChunk 下载一个分段，失败时换到下一个镜像重新下载这个分段
+func (u *Updater) downloadChunk(ctx context.Context, file *os.File, state *downloadState, i int, downloaded *int64) error {
+	start, end := state.bounds(i)
+
+	return u.tryMirrors(ctx, func(m Mirror) error {
+		written, err := u.fetchRange(ctx, m, file, state, start, end, downloaded)
+		if err != nil {
+			// 丢弃本次写入的进度，重新下载整个分段
+			atomic.AddInt64(downloaded, -written)
+		}
+		return err
+	}, func(err error) bool {
+		return errors.Is(err, errRemoteChanged)
+	})
+}
+
+// fetchRange 下载 [start, end] 范围写入文件对应位置，返回写入的字节数
+func (u *Updater) fetchRange(ctx context.Context, mirror Mirror, file *os.File, state *downloadState, start, end int64, downloaded *int64) (int64, error) {
+	url := mirror.packageURL(u.NewVer.Version, state.filename)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	validator := state.validator(mirror.Name)
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := u.getHTTPClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if validator != "" {
+			// 探测时服务器支持 Range，此时返回完整文件说明 If-Range 不再匹配
+			return 0, errRemoteChanged
+		}
+		return 0, errRangeUnsupported
+	default:
+		return 0, newHTTPStatusError(url, resp)
+	}
+
+	if !state.sameRemote(mirror.Name, contentRangeSize(resp.Header.Get("Content-Range")), resp.Header) {
+		return 0, errRemoteChanged
+	}
+	if want := fmt.Sprintf("bytes %d-%d/%d", start, end, state.Size); resp.Header.Get("Content-Range") != want {
+		return 0, fmt.Errorf("服务器返回的范围不匹配: %q", resp.Header.Get("Content-Range"))
+	}
+
+	buffer := u.newBuffer()
+	offset := start
+	for {
+		if u.debugMode {
+			if err := sleepContext(ctx, 100*time.Millisecond); err != nil {
+				return offset - start, err
+			}
+		}
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			if offset+int64(n) > end+1 {
+				return offset - start, fmt.Errorf("服务器返回的数据超出范围")
+			}
+			if _, writeErr := file.WriteAt(buffer[:n], offset); writeErr != nil {
+				return offset - start, writeErr
+			}
+			offset += int64(n)
+			u.SetProgress(downloadProgress(atomic.AddInt64(downloaded, int64(n)), state.Size))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return offset - start, err
+		}
+	}
+
+	if offset != end+1 {
+		return offset - start, fmt.Errorf("分段数据不完整")
+	}
+	return offset - start, nil
+}
+
+func (u *Updater) newBuffer() []byte {
+	if u.debugMode {
+		return make([]byte, 1)
+	}
+	return make([]byte, 32*1024)
+}
+
+// downloadProgress 下载阶段占总进度的 90%
+func downloadProgress(downloaded, total int64) float64 {
+	progress := float64(downloaded) / float64(total)
+	if progress > 0.9 {
+		progress = 0.9
+	} else if progress < 0 {
+		progress = 0
+	}
+	return progress
+}
+
+func hashFile(filePath string, hash hash.Hash) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(hash, file)
+	return err
+}
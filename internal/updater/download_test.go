@@ -0,0 +1,161 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testPackageVersion = "1.2.0"
+
+// testPackage 跨越多个分段、最后一个分段不完整的测试数据
+func testPackage() []byte {
+	data := make([]byte, 3*ChunkSize+12345)
+	for i := range data {
+		data[i] = byte(i * 31 % 251)
+	}
+	return data
+}
+
+// packageServer 提供 /<版本>/pkg.zip，记录收到的 Range 请求；rangeSupport 为 false 时忽略 Range
+func packageServer(t *testing.T, data []byte, rangeSupport bool) (*httptest.Server, func() []string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+testPackageVersion+"/pkg.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		if !rangeSupport {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data)
+			return
+		}
+		if rg := r.Header.Get("Range"); rg != "" {
+			mu.Lock()
+			ranges = append(ranges, rg)
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "pkg.zip", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, ranges...)
+	}
+}
+
+func newDownloadTestUpdater(t *testing.T, serverURL string) *Updater {
+	t.Helper()
+
+	dir := t.TempDir()
+	u := newUpdater(dir, dir, NewEventUI(nil))
+	u.Config.ManifestURL = serverURL + "/ver.ini"
+	u.Config.ReleaseURL = serverURL + "/%s/%s"
+	u.Config.Mirrors = nil
+	u.Config.Connections = DefaultConnections
+	if err := u.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	u.NewVer.Version = testPackageVersion
+	if err := os.MkdirAll(u.tempPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func testDigest(data []byte) Digest {
+	sum := sha256.Sum256(data)
+	return Digest{Algorithm: DigestSHA256, Value: hex.EncodeToString(sum[:])}
+}
+
+// downloadTestPackage 下载测试数据并确认写入 hash 的摘要和文件内容都正确
+func downloadTestPackage(t *testing.T, u *Updater, data []byte, filePath string) {
+	t.Helper()
+
+	digest := testDigest(data)
+	h := digest.newHash()
+	target := downloadTarget{Filename: "pkg.zip", Size: int64(len(data)), Digest: digest}
+	if err := u.download(context.Background(), target, filePath, h); err != nil {
+		t.Fatal(err)
+	}
+	if err := digest.Verify(h); err != nil {
+		t.Fatalf("下载时计算的摘要: %v", err)
+	}
+
+	got, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("下载的文件内容不正确")
+	}
+	if _, err := os.Stat(filePath + PartsFileSuffix); !os.IsNotExist(err) {
+		t.Fatalf("下载完成后仍有 %s 文件", PartsFileSuffix)
+	}
+}
+
+func TestDownloadChunked(t *testing.T) {
+	data := testPackage()
+	srv, ranges := packageServer(t, data, true)
+	u := newDownloadTestUpdater(t, srv.URL)
+
+	downloadTestPackage(t, u, data, u.tempPath("pkg.zip"))
+
+	// 探测请求加上 4 个分段
+	if got := len(ranges()); got != 5 {
+		t.Fatalf("收到 %d 个 Range 请求，want 5: %v", got, ranges())
+	}
+}
+
+func TestDownloadChunkedResume(t *testing.T) {
+	data := testPackage()
+	srv, ranges := packageServer(t, data, true)
+	u := newDownloadTestUpdater(t, srv.URL)
+	filePath := u.tempPath("pkg.zip")
+
+	// 上次下载完成了第 1、2 个分段，其余位置是无效数据
+	partial := bytes.Repeat([]byte{0xff}, len(data))
+	copy(partial[ChunkSize:3*ChunkSize], data[ChunkSize:3*ChunkSize])
+	if err := os.WriteFile(filePath, partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+	parts := fmt.Sprintf("version=%s\ndigest=%s\nmirror=%s\nsize=%d\netag=`\"v1\"`\nchunk_size=%d\ndone=1,2\n",
+		testPackageVersion, testDigest(data), DefaultMirrorName, len(data), ChunkSize)
+	if err := os.WriteFile(filePath+PartsFileSuffix, []byte(parts), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	downloadTestPackage(t, u, data, filePath)
+
+	for _, rg := range ranges() {
+		for _, i := range []int64{1, 2} {
+			if strings.HasPrefix(rg, fmt.Sprintf("bytes=%d-", i*ChunkSize)) {
+				t.Fatalf("重新下载了已完成的分段 %d", i)
+			}
+		}
+	}
+}
+
+func TestDownloadRangeUnsupported(t *testing.T) {
+	data := testPackage()
+	srv, _ := packageServer(t, data, false)
+	u := newDownloadTestUpdater(t, srv.URL)
+
+	downloadTestPackage(t, u, data, u.tempPath("pkg.zip"))
+}
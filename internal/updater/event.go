@@ -0,0 +1,190 @@
+package updater
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	EventCheckStarted  = "check_started"
+	EventVersionFound  = "version_found"
+	EventProgress      = "progress"
+	EventVerified      = "verified"
+	EventVerifyFailed  = "verify_failed"
+	EventInstalled     = "installed"
+	EventInstallFailed = "install_failed"
+	EventRolledBack    = "rolled_back"
+	EventLog           = "log"
+	EventError         = "error"
+	EventDone          = "done"
+)
+
+// 结束状态，与退出码一一对应
+const (
+	StatusNoUpdate       = "no_update"
+	StatusUpdated        = "updated"
+	StatusCancelled      = "cancelled"
+	StatusSignatureError = "signature_error"
+	StatusRelaunchFailed = "relaunch_failed"
+	StatusRolledBack     = "rolled_back"
+	// StatusUpdateAvailable 只检查时发现新版本
+	StatusUpdateAvailable = "update_available"
+	StatusStaged          = "staged"
+	StatusError           = "error"
+)
+
+// Event 更新过程中的结构化事件，没有用到的字段为空
+type Event struct {
+	Type string    `json:"event"`
+	Time time.Time `json:"time"`
+
+	CurrentVersion string `json:"current_version,omitempty"`
+	Version        string `json:"version,omitempty"`
+	Channel        string `json:"channel,omitempty"`
+	Size           int64  `json:"size,omitempty"`
+	Notes          string `json:"notes,omitempty"`
+	// UpdateAvailable 服务器版本与当前版本不同（包括服务器要求回退）
+	UpdateAvailable *bool `json:"update_available,omitempty"`
+	// Progress 下载和安装进度，0 到 1
+	Progress *float64 `json:"progress,omitempty"`
+
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	Status   string `json:"status,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+}
+
+// EventHandler 可选的界面接口，实现后除了日志文本还会收到结构化事件
+type EventHandler interface {
+	HandleEvent(e Event)
+}
+
+// emit 把事件发送给实现了 EventHandler 的界面
+func (u *Updater) emit(e Event) {
+	h, ok := u.UI.(EventHandler)
+	if !ok {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.HandleEvent(e)
+}
+
+// exitStatus 退出码对应的结束状态
+func exitStatus(code int) string {
+	switch code {
+	case ExitCodeNoUpdate:
+		return StatusNoUpdate
+	case ExitCodeNewVersion:
+		return StatusUpdated
+	case ExitCodeCancel:
+		return StatusCancelled
+	case ExitCodeSignature:
+		return StatusSignatureError
+	case ExitCodeRelaunch:
+		return StatusRelaunchFailed
+	case ExitCodeRolledBack:
+		return StatusRolledBack
+	case ExitCodeUpdateAvailable:
+		return StatusUpdateAvailable
+	case ExitCodeStaged:
+		return StatusStaged
+	}
+	return StatusError
+}
+
+// eventUI 把日志、进度和错误转换为事件交给 handler
+//
+// 不显示窗口也不等待确认，取消通过 ctx（例如 Ctrl+C）完成。
+type eventUI struct {
+	mu      sync.Mutex
+	handler func(Event)
+
+	// 上次输出的进度百分比，-1 表示还没有输出
+	percent int
+
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// NewJSONUI 创建向 w 输出换行分隔 JSON 事件的界面，供启动器和 CI 脚本解析
+func NewJSONUI(w io.Writer) UI {
+	enc := json.NewEncoder(w)
+	return NewEventUI(func(e Event) { enc.Encode(e) })
+}
+
+// NewEventUI 创建把事件交给 handler 的界面，handler 为 nil 时丢弃事件
+//
+// handler 不会被并发调用，但可能在不同的 goroutine 中调用，不应长时间阻塞。
+func NewEventUI(handler func(Event)) UI {
+	return &eventUI{
+		handler: handler,
+		percent: -1,
+		quit:    make(chan struct{}),
+	}
+}
+
+func (j *eventUI) HandleEvent(e Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.handler != nil {
+		j.handler(e)
+	}
+}
+
+func (j *eventUI) ShowMainWindow() {}
+
+func (j *eventUI) AppendLogText(text string) {
+	j.HandleEvent(Event{Type: EventLog, Time: time.Now(), Message: text})
+}
+
+func (j *eventUI) SetUpdateProgress(progress float64) {
+	percent := int(progress * 100)
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	j.mu.Lock()
+	if percent == j.percent {
+		j.mu.Unlock()
+		return
+	}
+	j.percent = percent
+	j.mu.Unlock()
+
+	p := float64(percent) / 100
+	j.HandleEvent(Event{Type: EventProgress, Time: time.Now(), Progress: &p})
+}
+
+func (j *eventUI) SetUpdateComplete() {}
+
+func (j *eventUI) ShowUpdateErrorDialog(message string) {
+	j.HandleEvent(Event{Type: EventError, Time: time.Now(), Error: message})
+}
+
+// ShowUpdateConfirmDialog 没有交互，始终确认
+func (j *eventUI) ShowUpdateConfirmDialog(message string) bool {
+	return true
+}
+
+func (j *eventUI) CloseWindow() {}
+
+func (j *eventUI) IsUpdateCancelled() bool {
+	return false
+}
+
+func (j *eventUI) AppLoop() {
+	<-j.quit
+}
+
+func (j *eventUI) UpdateFinished() {
+	j.quitOnce.Do(func() { close(j.quit) })
+}
@@ -0,0 +1,454 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// InstalledFilesFile 已安装版本的文件清单，保存在本地版本文件旁
+	InstalledFilesFile = "files.ini"
+	// FilesDir 按文件更新时下载文件的目录，位于 TempDir 下，中断后可以续传
+	FilesDir = "files"
+)
+
+// FileList 版本信息中引用的文件清单，清单本身由摘要保证内容正确
+type FileList struct {
+	Filename string
+	Digests  map[string]string
+}
+
+// FileEntry 文件清单中的一个文件
+//
+//	[bin/app.exe]
+//	size   = 1048576
+//	sha256 = ...
+//	mode   = 0755
+type FileEntry struct {
+	// Path 相对于安装目录、使用 "/" 分隔的路径
+	Path    string
+	Size    int64
+	Digests map[string]string
+	// Mode 文件权限，为 0 时使用 0644，Windows 上忽略
+	Mode os.FileMode
+}
+
+func (f FileEntry) perm() os.FileMode {
+	if f.Mode == 0 {
+		return 0644
+	}
+	return f.Mode.Perm()
+}
+
+// jsonFileManifest JSON 格式的文件清单
+//
+//	{"files": [{"path": "bin/app.exe", "size": 1048576, "digests": {"sha256": "..."}, "mode": "0755"}]}
+type jsonFileManifest struct {
+	Files []jsonFileEntry `json:"files"`
+}
+
+type jsonFileEntry struct {
+	Path    string            `json:"path"`
+	Size    int64             `json:"size"`
+	Digests map[string]string `json:"digests"`
+	Digest  string            `json:"digest"`
+	Mode    string            `json:"mode"`
+}
+
+// decodeFileList 解析 ini 或 JSON 格式的文件清单，并检查路径是否安全
+func decodeFileList(data []byte, contentType string, name string) ([]FileEntry, error) {
+	var files []FileEntry
+	var err error
+
+	switch manifestFormat(data, contentType, name) {
+	case ManifestJSON:
+		files, err = decodeJSONFileList(data)
+	default:
+		files, err = decodeINIFileList(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("无法解析文件清单: %v", err)
+	}
+
+	seen := make(map[string]bool, len(files))
+	for i, f := range files {
+		p, err := archiveEntryPath(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		if p == "." || f.Path == "" {
+			return nil, fmt.Errorf("文件清单中的路径无效: %q", f.Path)
+		}
+		if seen[p] {
+			return nil, fmt.Errorf("文件清单中的路径重复: %s", p)
+		}
+		if len(f.Digests) == 0 {
+			return nil, fmt.Errorf("文件 %s 缺少摘要", p)
+		}
+		seen[p] = true
+		files[i].Path = p
+	}
+	return files, nil
+}
+
+func decodeINIFileList(data []byte) ([]FileEntry, error) {
+	// 路径中包含 "."，不使用子节
+	cfg, err := ini.LoadSources(ini.LoadOptions{ChildSectionDelimiter: "\x00"}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileEntry
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+		f := FileEntry{Path: section.Name(), Size: section.Key("size").MustInt64(0)}
+		if f.Digests, err = parseSectionDigests(section); err != nil {
+			return nil, fmt.Errorf("[%s]: %v", f.Path, err)
+		}
+		if f.Mode, err = parseFileMode(section.Key("mode").String()); err != nil {
+			return nil, fmt.Errorf("[%s]: %v", f.Path, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func decodeJSONFileList(data []byte) ([]FileEntry, error) {
+	var m jsonFileManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	var files []FileEntry
+	for _, jf := range m.Files {
+		digests, err := parseDigests(jf.Digests, jf.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("文件 %s: %v", jf.Path, err)
+		}
+		mode, err := parseFileMode(jf.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("文件 %s: %v", jf.Path, err)
+		}
+		files = append(files, FileEntry{Path: jf.Path, Size: jf.Size, Digests: digests, Mode: mode})
+	}
+	return files, nil
+}
+
+// parseFileMode 读取八进制的文件权限，空值表示未指定
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("无效的文件权限: %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// readFileList 读取本地保存的文件清单，不存在或无法解析时返回 nil
+func readFileList(filePath string) []FileEntry {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+	files, err := decodeFileList(data, "", filePath)
+	if err != nil {
+		return nil
+	}
+	return files
+}
+
+// writeFileList 以 ini 格式保存文件清单
+func writeFileList(filePath string, files []FileEntry) error {
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "[%s]\nsize = %d\n", f.Path, f.Size)
+		for _, algo := range digestAlgorithms {
+			if value, ok := f.Digests[algo]; ok {
+				fmt.Fprintf(&b, "%s = %s\n", algo, value)
+			}
+		}
+		if f.Mode != 0 {
+			fmt.Fprintf(&b, "mode = %04o\n", f.Mode)
+		}
+		b.WriteString("\n")
+	}
+
+	// 先写入临时文件再重命名，避免留下不完整的清单
+	tmpPath := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, []byte(b.String()), 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+func (u *Updater) installedFilesPath() string {
+	return filepath.Join(filepath.Dir(u.versionFilePath), InstalledFilesFile)
+}
+
+// fetchFileList 下载新版本的文件清单并用版本信息中的摘要校验，同时返回文件清单的原始内容
+func (u *Updater) fetchFileList(ctx context.Context) ([]FileEntry, []byte, error) {
+	digest, err := strongestDigest(u.NewVer.Files.Digests, u.AllowMD5)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var data []byte
+	var contentType, listURL string
+	err = u.tryMirrors(ctx, func(m Mirror) error {
+		var err error
+		listURL = m.packageURL(u.NewVer.Version, u.NewVer.Files.Filename)
+		data, contentType, err = u.fetchResource(ctx, listURL)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := digest.newHash()
+	h.Write(data)
+	if err := digest.Verify(h); err != nil {
+		return nil, nil, fmt.Errorf("文件清单校验失败: %v", err)
+	}
+	files, err := decodeFileList(data, contentType, listURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return files, data, nil
+}
+
+// fileChange 与本地安装相比需要更新的文件
+type fileChange struct {
+	entry FileEntry
+	added bool
+}
+
+// diffFiles 比较新版本的文件清单与安装目录，返回需要下载的文件和需要删除的文件
+//
+// 只删除旧版本清单中列出、新版本不再包含的文件，安装目录中的其他文件保持不变。
+func (u *Updater) diffFiles(root string, files []FileEntry, previous []FileEntry) (changes []fileChange, removed []string, unchanged int, err error) {
+	wanted := make(map[string]bool, len(files))
+	for _, f := range files {
+		wanted[f.Path] = true
+
+		target, err := containedPath(root, f.Path)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		info, err := os.Lstat(target)
+		if os.IsNotExist(err) {
+			changes = append(changes, fileChange{entry: f, added: true})
+			continue
+		} else if err != nil {
+			return nil, nil, 0, fmt.Errorf("读取文件信息失败: %v", err)
+		}
+
+		same, err := u.sameFile(target, info, f)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if same {
+			unchanged++
+		} else {
+			changes = append(changes, fileChange{entry: f})
+		}
+	}
+
+	for _, f := range previous {
+		if wanted[f.Path] {
+			continue
+		}
+		target, err := containedPath(root, f.Path)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Lstat(target); err == nil {
+			removed = append(removed, filepath.FromSlash(f.Path))
+		}
+	}
+	sort.Strings(removed)
+
+	return changes, removed, unchanged, nil
+}
+
+// sameFile 本地文件的大小、权限和摘要是否都与清单一致
+func (u *Updater) sameFile(target string, info os.FileInfo, f FileEntry) (bool, error) {
+	if !info.Mode().IsRegular() || info.Size() != f.Size {
+		return false, nil
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != f.perm() {
+		return false, nil
+	}
+
+	digest, err := strongestDigest(f.Digests, u.AllowMD5)
+	if err != nil {
+		return false, err
+	}
+	h := digest.newHash()
+	if err := hashFile(target, h); err != nil {
+		return false, err
+	}
+	return digest.Verify(h) == nil, nil
+}
+
+// stageFiles 按文件清单下载变化的文件，不修改安装目录中的文件
+func (u *Updater) stageFiles(ctx context.Context) (*stagedUpdate, error) {
+	files, list, err := u.fetchFileList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, removed, unchanged, err := u.diffFiles(u.dir, files, readFileList(u.installedFilesPath()))
+	if err != nil {
+		return nil, err
+	}
+
+	added := 0
+	for _, c := range changes {
+		if c.added {
+			added++
+		}
+	}
+	u.log.Info(fmt.Sprintf("文件变化: 新增 %d, 修改 %d, 删除 %d, 未变 %d",
+		added, len(changes)-added, len(removed), unchanged),
+		"added", added, "changed", len(changes)-added, "removed", len(removed), "unchanged", unchanged)
+
+	downloadDir := u.tempPath(FilesDir)
+	for _, c := range changes {
+		if err := u.downloadFile(ctx, downloadDir, c.entry); err != nil {
+			return nil, fmt.Errorf("下载文件 %s 失败: %v", c.entry.Path, err)
+		}
+	}
+	// 每个文件下载后都已校验摘要
+	u.emit(Event{Type: EventVerified, Version: u.NewVer.Version})
+
+	return &stagedUpdate{
+		Version:  u.NewVer,
+		Files:    files,
+		fileList: list,
+		Variant:  u.Config.Variant,
+		compared: true,
+		changes:  changes,
+		removed:  removed,
+	}, nil
+}
+
+// installFiles 替换变化的文件，删除新版本不再包含的文件
+//
+// 从 staged.ini 读取的更新重新比较安装目录，需要的文件必须已经下载。
+func (u *Updater) installFiles(ctx context.Context, staged *stagedUpdate) (*installTransaction, error) {
+	if err := u.waitForApp(ctx); err != nil {
+		return nil, err
+	}
+
+	downloadDir := u.tempPath(FilesDir)
+	changes, removed := staged.changes, staged.removed
+	if !staged.compared {
+		var err error
+		changes, removed, _, err = u.diffFiles(u.dir, staged.Files, readFileList(u.installedFilesPath()))
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range changes {
+			src := filepath.Join(downloadDir, filepath.FromSlash(c.entry.Path))
+			info, err := os.Lstat(src)
+			if err != nil {
+				return nil, fmt.Errorf("文件 %s 没有下载，请重新下载更新", c.entry.Path)
+			}
+			if same, err := u.sameFile(src, info, c.entry); err != nil || !same {
+				return nil, fmt.Errorf("已下载的文件 %s 校验失败，请重新下载更新", c.entry.Path)
+			}
+		}
+	}
+
+	tx, err := newInstallTransaction(u.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		src := filepath.Join(downloadDir, filepath.FromSlash(c.entry.Path))
+		dst := filepath.Join(tx.stagingDir, filepath.FromSlash(c.entry.Path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			tx.rollback()
+			return nil, fmt.Errorf("创建目录失败: %v", err)
+		}
+		if err := os.Rename(src, dst); err != nil {
+			tx.rollback()
+			return nil, fmt.Errorf("暂存文件失败: %v", err)
+		}
+		tx.files = append(tx.files, filepath.FromSlash(c.entry.Path))
+	}
+	tx.removed = removed
+
+	if err := tx.apply(); err != nil {
+		u.emit(Event{Type: EventInstallFailed, Version: u.NewVer.Version, Error: err.Error()})
+		return nil, fmt.Errorf("更新失败: %w", err)
+	}
+	os.RemoveAll(downloadDir)
+	return tx, nil
+}
+
+// downloadFile 下载一个文件到 dir 下对应的路径，校验摘要并设置权限
+func (u *Updater) downloadFile(ctx context.Context, dir string, f FileEntry) error {
+	digest, err := strongestDigest(f.Digests, u.AllowMD5)
+	if err != nil {
+		return err
+	}
+
+	filePath, err := containedPath(dir, f.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	// 上次已下载完成但没有安装的文件直接使用
+	h := digest.newHash()
+	if _, err := os.Stat(filePath + PartsFileSuffix); os.IsNotExist(err) && hashFile(filePath, h) == nil && digest.Verify(h) == nil {
+		return setFileMode(filePath, f)
+	}
+
+	h = digest.newHash()
+	target := downloadTarget{Filename: escapeFilePath(f.Path), Size: f.Size, Digest: digest}
+	if err := u.download(ctx, target, filePath, h); err != nil {
+		return err
+	}
+	if err := digest.Verify(h); err != nil {
+		discardPartial(filePath)
+		return err
+	}
+	return setFileMode(filePath, f)
+}
+
+// setFileMode 按清单设置文件权限，Windows 上忽略
+func setFileMode(filePath string, f FileEntry) error {
+	if runtime.GOOS != "windows" {
+		return os.Chmod(filePath, f.perm())
+	}
+	return nil
+}
+
+// escapeFilePath 对路径的每一段分别转义，用于拼接到更新包地址中
+func escapeFilePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,172 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHealthTimeout = 30 * time.Second
+
+	// maxHealthOutput 健康检查失败时记录的命令输出长度上限
+	maxHealthOutput = 512
+)
+
+// ErrUnhealthy 新版本没有通过健康检查
+var ErrUnhealthy = errors.New("新版本没有通过健康检查")
+
+// HealthCheck 安装后的健康检查，没有配置命令和标记文件时不检查
+//
+//	[health]
+//	command = "./My App" --self-test
+//	marker  = started.ok
+//	timeout = 30s
+type HealthCheck struct {
+	// Command 在安装目录中执行的命令和参数，退出码为 0 表示正常，
+	// updater.ini 中包含空白的参数用单引号或双引号括起来
+	Command []string
+	// Marker 新版本启动成功后写入的标记文件，安装后先删除旧的标记
+	Marker string
+	// Timeout 命令执行和等待标记文件的总时间
+	Timeout time.Duration
+}
+
+// Enabled 是否配置了健康检查
+func (h HealthCheck) Enabled() bool {
+	return len(h.Command) > 0 || h.Marker != ""
+}
+
+// Validate 检查健康检查配置
+func (h HealthCheck) Validate() error {
+	if h.Enabled() && h.Timeout <= 0 {
+		return fmt.Errorf("健康检查的超时时间必须大于 0: %v", h.Timeout)
+	}
+	return nil
+}
+
+// checkHealth 执行健康检查命令并等待标记文件，ctx 被取消时返回 ctx 的错误
+func (u *Updater) checkHealth(ctx context.Context) error {
+	h := u.Config.Health
+
+	checkCtx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	if len(h.Command) > 0 {
+		cmd := exec.CommandContext(checkCtx, h.Command[0], h.Command[1:]...)
+		cmd.Dir = u.dir
+		output, err := cmd.CombinedOutput()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if checkCtx.Err() != nil {
+			return fmt.Errorf("%w: 检查命令在 %v 内没有结束", ErrUnhealthy, h.Timeout)
+		}
+		if err != nil {
+			out := strings.TrimSpace(string(output))
+			if len(out) > maxHealthOutput {
+				out = out[:maxHealthOutput] + "..."
+			}
+			return fmt.Errorf("%w: 检查命令失败: %v %s", ErrUnhealthy, err, out)
+		}
+	}
+
+	if h.Marker != "" {
+		for {
+			if _, err := os.Stat(h.Marker); err == nil {
+				break
+			}
+			if err := sleepContext(checkCtx, waitPollInterval); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return fmt.Errorf("%w: %v 内没有出现启动标记 %s", ErrUnhealthy, h.Timeout, h.Marker)
+			}
+		}
+	}
+
+	return nil
+}
+
+// startAndCheck 按配置重新启动程序并执行健康检查，没有通过时回滚到上一版本
+//
+// 返回 ExitCodeNewVersion 表示新版本可以使用。
+func (u *Updater) startAndCheck(ctx context.Context) int {
+	h := u.Config.Health
+
+	// 旧的标记文件不能证明新版本已经启动
+	if h.Marker != "" {
+		os.Remove(h.Marker)
+	}
+
+	u.phase(phaseHealth)
+	var proc *os.Process
+	var err error
+	if u.Relaunch != nil {
+		proc, err = u.relaunch(ctx)
+	}
+	if err == nil && h.Enabled() {
+		u.log.Info("检查新版本是否正常运行...", "version", u.NewVer.Version)
+		start := time.Now()
+		err = u.checkHealth(ctx)
+		if err != nil && ctx.Err() != nil {
+			// 取消检查时保留新版本
+			u.log.Warn("健康检查被取消")
+			return ExitCodeCancel
+		}
+		if err == nil {
+			u.log.Info("健康检查通过", "duration", time.Since(start))
+		}
+	}
+	if err == nil {
+		return ExitCodeNewVersion
+	}
+
+	u.lastErr = err
+	u.log.Error(err.Error(), "version", u.NewVer.Version)
+	if !h.Enabled() {
+		return ExitCodeRelaunch
+	}
+	return u.rollbackUnhealthy(ctx, proc, err)
+}
+
+// rollbackUnhealthy 结束新启动的程序，恢复上一版本并记录失败的版本
+func (u *Updater) rollbackUnhealthy(ctx context.Context, proc *os.Process, cause error) int {
+	if proc != nil {
+		proc.Kill()
+		for i := 0; i < 25 && processRunning(proc.Pid); i++ {
+			sleepContext(ctx, waitPollInterval)
+		}
+	}
+	// 程序可能由启动器拉起，按等待配置请求它关闭
+	if err := u.waitForApp(ctx); err != nil {
+		u.log.Warn(fmt.Sprintf("等待程序退出失败: %v", err))
+	}
+
+	u.phase(phaseRollback)
+	u.log.Info(fmt.Sprintf("回滚版本 %s...", u.NewVer.Version), "current_version", u.NewVer.Version)
+	version, err := u.rollbackPrevious()
+	if err != nil {
+		u.lastErr = err
+		u.log.Error(err.Error())
+		return ExitCodeError
+	}
+	u.log.Info(fmt.Sprintf("已回滚到版本 %s", version), "version", version, "replaced_version", u.NewVer.Version)
+	u.emit(Event{Type: EventRolledBack, CurrentVersion: version, Version: u.NewVer.Version, Error: cause.Error()})
+
+	if err := u.recordFailedVersion(u.NewVer.Version, cause.Error()); err != nil {
+		u.log.Warn(fmt.Sprintf("记录失败的版本失败: %v", err))
+	}
+	u.reloadVersion()
+
+	if u.Relaunch != nil {
+		if _, err := u.relaunch(ctx); err != nil {
+			u.log.Error(err.Error())
+		}
+	}
+	return ExitCodeRolledBack
+}
@@ -0,0 +1,67 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCheckHealthRunsInInstallDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("需要 sh")
+	}
+
+	dir := t.TempDir()
+	u := newUpdater(dir, dir, NewEventUI(nil))
+	if err := os.WriteFile(filepath.Join(dir, "self test.sh"), []byte("test -f app.txt\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	u.Config.Health = HealthCheck{Command: []string{"sh", "self test.sh"}, Timeout: 5 * time.Second}
+
+	if err := u.checkHealth(context.Background()); err == nil {
+		t.Fatal("安装目录中没有 app.txt 时 checkHealth() 没有返回错误")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.checkHealth(context.Background()); err != nil {
+		t.Fatalf("checkHealth() = %v", err)
+	}
+}
+
+func TestStartAndCheckRollsBack(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("需要 false 命令")
+	}
+
+	dir := t.TempDir()
+	u := newUpdater(dir, dir, NewEventUI(nil))
+	u.Config.Wait.Close = CloseNone
+
+	// 上一版本 1.0.0 的记录，1.1.0 已经安装
+	previous := u.previousDir()
+	if err := os.MkdirAll(previous, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(previous, PreviousRecordFile), []byte("version = 1.0.0\nreplaced_by = 1.1.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(previous, VersionFile), []byte(stagedTestManifest("1.0.0", testSHA256, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(u.versionFilePath, []byte(stagedTestManifest("1.1.0", testSHA256, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u.CurrentVer.Version, u.NewVer.Version = "1.1.0", "1.1.0"
+	u.Config.Health = HealthCheck{Command: []string{"false"}, Timeout: 5 * time.Second}
+
+	if code := u.startAndCheck(context.Background()); code != ExitCodeRolledBack {
+		t.Fatalf("startAndCheck() = %d, want %d", code, ExitCodeRolledBack)
+	}
+	if u.CurrentVer.Version != "1.0.0" {
+		t.Fatalf("CurrentVer = %s, want 1.0.0", u.CurrentVer.Version)
+	}
+}
@@ -0,0 +1,271 @@
+package updater
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	TempDir    = "tmp"
+	StagingDir = "staging"
+	BackupDir  = "backup"
+	// InstalledDir 保留当前版本的完整更新包，作为下次增量更新的基础
+	InstalledDir = "installed"
+)
+
+// installStep 记录一次替换，用于回滚
+type installStep struct {
+	target string
+	// backup 为空表示目标文件原本不存在
+	backup string
+}
+
+// installTransaction 事务式安装
+//
+// 更新包先完整解压到暂存目录，然后把将被覆盖的文件移动到备份目录，
+// 再把暂存的文件移动到安装目录。任何一步失败都会按相反顺序恢复备份，
+// 安装目录不会停留在新旧版本混合的状态。
+type installTransaction struct {
+	root       string
+	stagingDir string
+	backupDir  string
+
+	files []string
+	// removed 要删除的文件，删除前同样移动到备份目录
+	removed []string
+	steps   []installStep
+	dirs    []string
+}
+
+// newInstallTransaction 清理上次遗留的暂存和备份目录
+func newInstallTransaction(root string) (*installTransaction, error) {
+	tx := &installTransaction{
+		root:       root,
+		stagingDir: filepath.Join(root, TempDir, StagingDir),
+		backupDir:  filepath.Join(root, TempDir, BackupDir),
+	}
+
+	for _, dir := range []string{tx.stagingDir, tx.backupDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmt.Errorf("清理临时目录失败: %v", err)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("创建临时目录失败: %v", err)
+		}
+	}
+
+	return tx, nil
+}
+
+// stageZip 把更新包解压到暂存目录，拒绝不安全的条目
+func (tx *installTransaction) stageZip(zipPath string, limits ArchiveLimits) error {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("打开 ZIP 文件失败: %v", err)
+	}
+	defer reader.Close()
+
+	if err := checkArchive(reader.File, limits); err != nil {
+		return err
+	}
+
+	remaining := limits.MaxUnpackedSize
+	if remaining <= 0 {
+		remaining = math.MaxInt64
+	}
+
+	for _, file := range reader.File {
+		name, err := archiveEntryPath(file.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		filePath, err := containedPath(tx.stagingDir, name)
+		if err != nil {
+			return err
+		}
+		if err := checkParentDirs(tx.stagingDir, name); err != nil {
+			return err
+		}
+		if err := checkEntryMode(file); err != nil {
+			return err
+		}
+
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
+				return fmt.Errorf("创建目录失败: %v", err)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			return fmt.Errorf("创建目录失败: %v", err)
+		}
+
+		if file.Mode()&os.ModeSymlink != 0 {
+			switch limits.SymlinkPolicy {
+			case SymlinkSkip:
+				continue
+			case SymlinkAllow:
+				target, err := readSymlinkTarget(file, name)
+				if err != nil {
+					return err
+				}
+				if err := os.Symlink(target, filePath); err != nil {
+					return fmt.Errorf("创建符号链接失败: %v", err)
+				}
+				tx.files = append(tx.files, filepath.FromSlash(name))
+				continue
+			default:
+				return &ArchiveError{Entry: file.Name, Err: ErrSymlink}
+			}
+		}
+
+		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("创建文件失败: %v", err)
+		}
+
+		srcFile, err := file.Open()
+		if err != nil {
+			dstFile.Close()
+			return fmt.Errorf("打开 ZIP 文件内容失败: %v", err)
+		}
+
+		_, err = io.Copy(&limitedWriter{w: dstFile, remaining: &remaining, entry: file.Name}, srcFile)
+		srcFile.Close()
+		if closeErr := dstFile.Close(); err == nil {
+			err = closeErr
+		}
+
+		var archiveErr *ArchiveError
+		if errors.As(err, &archiveErr) {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("复制文件内容失败: %v", err)
+		}
+
+		tx.files = append(tx.files, filepath.FromSlash(name))
+	}
+
+	return nil
+}
+
+// apply 备份并替换文件，失败时自动回滚
+func (tx *installTransaction) apply() (err error) {
+	defer func() {
+		if err != nil {
+			if rbErr := tx.rollback(); rbErr != nil {
+				err = fmt.Errorf("%v; 回滚失败: %v", err, rbErr)
+			}
+		}
+	}()
+
+	for _, name := range tx.files {
+		target := filepath.Join(tx.root, name)
+		if err := tx.mkdirAll(filepath.Dir(target)); err != nil {
+			return fmt.Errorf("创建目录失败: %v", err)
+		}
+
+		step := installStep{target: target}
+		if _, err := os.Lstat(target); err == nil {
+			step.backup = filepath.Join(tx.backupDir, name)
+			if err := os.MkdirAll(filepath.Dir(step.backup), 0755); err != nil {
+				return fmt.Errorf("创建备份目录失败: %v", err)
+			}
+			if err := os.Rename(target, step.backup); err != nil {
+				return fmt.Errorf("备份文件失败: %v", err)
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("读取文件信息失败: %v", err)
+		}
+		tx.steps = append(tx.steps, step)
+
+		if err := os.Rename(filepath.Join(tx.stagingDir, name), target); err != nil {
+			return fmt.Errorf("替换文件失败: %v", err)
+		}
+	}
+
+	for _, name := range tx.removed {
+		step := installStep{target: filepath.Join(tx.root, name), backup: filepath.Join(tx.backupDir, name)}
+		if err := os.MkdirAll(filepath.Dir(step.backup), 0755); err != nil {
+			return fmt.Errorf("创建备份目录失败: %v", err)
+		}
+		if err := os.Rename(step.target, step.backup); err != nil {
+			return fmt.Errorf("删除文件失败: %v", err)
+		}
+		tx.steps = append(tx.steps, step)
+	}
+
+	return nil
+}
+
+// mkdirAll 创建目录并记录新建的目录，回滚时删除
+func (tx *installTransaction) mkdirAll(dir string) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		created = append(created, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tx.dirs = append(tx.dirs, created...)
+	return nil
+}
+
+// rollback 按相反顺序撤销已替换的文件并恢复备份
+func (tx *installTransaction) rollback() error {
+	var errs []string
+
+	for i := len(tx.steps) - 1; i >= 0; i-- {
+		step := tx.steps[i]
+		if err := os.RemoveAll(step.target); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if step.backup != "" {
+			if err := os.Rename(step.backup, step.target); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	tx.steps = nil
+
+	// 先删除深层目录
+	sort.Sort(sort.Reverse(sort.StringSlice(tx.dirs)))
+	for _, dir := range tx.dirs {
+		os.Remove(dir)
+	}
+	tx.dirs = nil
+
+	os.RemoveAll(tx.stagingDir)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// commit 安装成功，删除暂存和备份文件
+func (tx *installTransaction) commit() {
+	os.RemoveAll(tx.stagingDir)
+	os.RemoveAll(tx.backupDir)
+	tx.steps = nil
+	tx.dirs = nil
+}
@@ -0,0 +1,285 @@
+package updater
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// LogFile 默认的日志文件，保存在本地版本文件旁
+	LogFile = "updater.log"
+	// LogNone 不写日志文件
+	LogNone = "none"
+
+	DefaultLogMaxSize  = 1024 * 1024 // 1MB
+	DefaultLogMaxFiles = 3
+)
+
+// 运行中的阶段，记录在每条日志的 phase 字段中
+const (
+	phaseCheck    = "check"
+	phaseDownload = "download"
+	phaseInstall  = "install"
+	phaseHealth   = "health"
+	phaseRollback = "rollback"
+)
+
+// LogConfig 日志文件配置
+//
+//	[log]
+//	file      = logs/updater.log
+//	level     = debug
+//	max_size  = 1048576
+//	max_files = 3
+type LogConfig struct {
+	// File 日志文件路径，LogNone 表示不写日志文件
+	File string
+	// Level 最低日志级别，同时作用于界面和日志文件
+	Level slog.Level
+	// MaxSize 日志文件超过这个大小时轮转
+	MaxSize int64
+	// MaxFiles 轮转后保留的旧日志文件数量
+	MaxFiles int
+}
+
+// DefaultLogConfig 默认日志文件位于 dir 下
+func DefaultLogConfig(dir string) LogConfig {
+	return LogConfig{
+		File:     filepath.Join(dir, LogFile),
+		Level:    slog.LevelInfo,
+		MaxSize:  DefaultLogMaxSize,
+		MaxFiles: DefaultLogMaxFiles,
+	}
+}
+
+// Validate 检查日志配置
+func (c LogConfig) Validate() error {
+	if c.File == "" {
+		return fmt.Errorf("没有指定日志文件")
+	}
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("日志文件大小上限需要大于 0: %d", c.MaxSize)
+	}
+	if c.MaxFiles < 0 {
+		return fmt.Errorf("保留的日志文件数量不能为负数: %d", c.MaxFiles)
+	}
+	return nil
+}
+
+// ParseLogLevel 解析日志级别: debug、info、warn 或 error
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("未知的日志级别: %q", s)
+	}
+	return level, nil
+}
+
+// startRun 开始一次运行：生成运行 ID，打开日志文件
+//
+// file 为 false 时只输出到界面，用于不写入任何文件的检查。
+func (u *Updater) startRun(command string, file bool) {
+	u.lastErr = nil
+	u.runStart = time.Now()
+	u.closeLog()
+
+	handlers := []slog.Handler{&uiHandler{ui: u.UI, level: u.Config.Log.Level}}
+	if file && u.Config.Log.File != LogNone {
+		f, err := openRotatingFile(u.Config.Log.File, u.Config.Log.MaxSize, u.Config.Log.MaxFiles)
+		if err != nil {
+			u.UI.AppendLogText(fmt.Sprintf("无法打开日志文件: %v", err))
+		} else {
+			u.logFile = f
+			handlers = append(handlers, slog.NewJSONHandler(f, &slog.HandlerOptions{
+				Level:       u.Config.Log.Level,
+				ReplaceAttr: formatDuration,
+			}))
+		}
+	}
+	handlers = append(handlers, u.logHandlers...)
+
+	u.runLog = slog.New(fanoutHandler(handlers)).With("run", newRunID(), "command", command)
+	u.log = u.runLog
+	u.log.Debug(fmt.Sprintf("开始运行: %s", command), "current_version", u.CurrentVer.Version, "channel", u.Channel, "dir", u.dir)
+}
+
+// phase 之后的日志记录在阶段 name 中
+func (u *Updater) phase(name string) {
+	u.log = u.runLog.With("phase", name)
+}
+
+// closeLog 关闭本次运行的日志文件
+func (u *Updater) closeLog() {
+	if u.logFile != nil {
+		u.logFile.Close()
+		u.logFile = nil
+	}
+}
+
+// formatDuration 日志文件中的时长写成 "1.5s" 这样的文本
+func formatDuration(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.String(a.Key, a.Value.Duration().String())
+	}
+	return a
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// uiHandler 把日志消息显示在界面上，字段只写入日志文件
+type uiHandler struct {
+	ui    UI
+	level slog.Leveler
+}
+
+func (h *uiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *uiHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.ui.AppendLogText(r.Message)
+	return nil
+}
+
+func (h *uiHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+
+func (h *uiHandler) WithGroup(name string) slog.Handler { return h }
+
+// fanoutHandler 把同一条日志交给多个 handler
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// rotatingFile 追加写入的日志文件，超过 maxSize 时依次重命名为 .1、.2 …，
+// 最多保留 maxFiles 个旧文件
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate 关闭当前文件，把旧文件依次后移一位，然后重新创建日志文件
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	r.f = nil
+
+	os.Remove(r.path + "." + strconv.Itoa(r.maxFiles))
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+	}
+	if r.maxFiles > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,467 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	ManifestINI  = "ini"
+	ManifestJSON = "json"
+)
+
+// manifestFormat 根据 Content-Type 或文件扩展名判断版本信息格式，都无法判断时根据内容判断
+func manifestFormat(data []byte, contentType string, name string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			return ManifestJSON
+		case strings.Contains(mediaType, "ini"):
+			return ManifestINI
+		}
+	}
+
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return ManifestJSON
+	case ".ini":
+		return ManifestINI
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return ManifestJSON
+	}
+	return ManifestINI
+}
+
+// decodeManifest 解析版本信息，返回其中列出的所有版本
+//
+// contentType 和 name（URL 或文件路径）用于判断格式，可以为空
+func decodeManifest(data []byte, contentType string, name string) ([]VersionInfo, error) {
+	var releases []VersionInfo
+	var err error
+
+	switch manifestFormat(data, contentType, name) {
+	case ManifestJSON:
+		releases, err = decodeJSONManifest(data)
+	default:
+		releases, err = decodeINIManifest(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("无法解析版本信息: %v", err)
+	}
+
+	if err := validateReleases(releases); err != nil {
+		return nil, fmt.Errorf("无法解析版本信息: %v", err)
+	}
+
+	for i := range releases {
+		releases[i].RawData = data
+	}
+	return releases, nil
+}
+
+// validateReleases 检查各格式共同的约束
+func validateReleases(releases []VersionInfo) error {
+	if len(releases) == 0 {
+		return fmt.Errorf("没有任何版本")
+	}
+
+	for i, vi := range releases {
+		if vi.Version == "" {
+			return fmt.Errorf("无效的版本文件格式")
+		}
+		if _, err := ParseVersion(vi.Version); err != nil {
+			return err
+		}
+		if vi.MinVersion != "" {
+			if _, err := ParseVersion(vi.MinVersion); err != nil {
+				return fmt.Errorf("版本 %s: %v", vi.Version, err)
+			}
+		}
+
+		channel, err := normalizeChannel(vi.Channel)
+		if err != nil {
+			return fmt.Errorf("版本 %s: %v", vi.Version, err)
+		}
+		releases[i].Channel = channel
+
+		if vi.FullPackageURL == "" {
+			return fmt.Errorf("版本 %s 缺少完整安装包地址", vi.Version)
+		}
+		if vi.Filename == "" && len(vi.Artifacts) == 0 {
+			return fmt.Errorf("版本 %s 没有更新包", vi.Version)
+		}
+		if vi.Filename != "" && len(vi.Digests) == 0 {
+			return fmt.Errorf("版本 %s 缺少摘要", vi.Version)
+		}
+		if err := validatePatches(vi.Version, vi.Patches); err != nil {
+			return err
+		}
+		if vi.Files.Filename != "" && len(vi.Files.Digests) == 0 {
+			return fmt.Errorf("版本 %s 的文件清单缺少摘要", vi.Version)
+		}
+		for _, a := range vi.Artifacts {
+			if a.OS == "" || a.Arch == "" {
+				return fmt.Errorf("版本 %s 中的更新包缺少平台", vi.Version)
+			}
+			if a.Filename == "" || len(a.Digests) == 0 {
+				return fmt.Errorf("版本 %s 平台 %s 缺少文件名或摘要", vi.Version, a.Platform())
+			}
+			if err := validatePatches(vi.Version, a.Patches); err != nil {
+				return err
+			}
+			if a.Files.Filename != "" && len(a.Files.Digests) == 0 {
+				return fmt.Errorf("版本 %s 平台 %s 的文件清单缺少摘要", vi.Version, a.Platform())
+			}
+		}
+	}
+
+	return nil
+}
+
+// validatePatches 检查补丁的起始版本、文件名和摘要
+func validatePatches(version string, patches []Patch) error {
+	for _, p := range patches {
+		if _, err := ParseVersion(p.From); err != nil {
+			return fmt.Errorf("版本 %s 的补丁: %v", version, err)
+		}
+		if p.Filename == "" || len(p.Digests) == 0 {
+			return fmt.Errorf("版本 %s 从 %s 的补丁缺少文件名或摘要", version, p.From)
+		}
+	}
+	return nil
+}
+
+// decodeINIManifest 读取 ini 格式的版本信息
+//
+// 旧格式只有一个默认节，视为 stable 通道的唯一版本；
+// 新格式每个节描述一个版本，节名缺省作为版本号；
+// 名为 "<版本> <os>/<arch>[/<variant>]" 的节描述该版本某个平台的更新包:
+//
+//	[1.2.0-beta.1]
+//	channel = beta
+//	fullpackage = ...
+//
+//	[1.2.0-beta.1 linux/amd64/musl]
+//	filename = update_1.2.0-beta.1_linux_amd64_musl.zip
+//	sha256 = ...
+//	files = files_1.2.0-beta.1_linux_amd64_musl.ini
+//	files_digest = sha256:...
+//
+// 节名以 "from <旧版本>" 结尾时描述从旧版本完整更新包到该更新包的补丁，
+// 不带平台时对应通用更新包:
+//
+//	[1.2.0-beta.1 linux/amd64/musl from 1.1.0]
+//	filename = update_1.1.0_1.2.0-beta.1_linux_amd64_musl.bsdiff
+//	sha256 = ...
+func decodeINIManifest(data []byte) ([]VersionInfo, error) {
+	// 版本号中包含 "."，不能作为子节分隔符
+	cfg, err := ini.LoadSources(ini.LoadOptions{ChildSectionDelimiter: ":"}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []VersionInfo
+	index := make(map[string]int)
+
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection || strings.Contains(section.Name(), " ") {
+			continue
+		}
+		if !section.HasKey("version") {
+			section.Key("version").SetValue(section.Name())
+		}
+		vi, err := parseVersionSection(section)
+		if err != nil {
+			return nil, fmt.Errorf("版本 [%s]: %v", section.Name(), err)
+		}
+		index[section.Name()] = len(releases)
+		releases = append(releases, vi)
+	}
+
+	var patchSections []*ini.Section
+	for _, section := range cfg.Sections() {
+		fields := strings.Fields(section.Name())
+		if len(fields) < 2 {
+			continue
+		}
+		i, ok := index[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("[%s]: 未定义的版本 %s", section.Name(), fields[0])
+		}
+		if n := len(fields); n >= 3 && strings.EqualFold(fields[n-2], "from") {
+			patchSections = append(patchSections, section)
+			continue
+		}
+		a, err := parseArtifactSection(strings.Join(fields[1:], ""), section)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %v", section.Name(), err)
+		}
+		releases[i].Artifacts = append(releases[i].Artifacts, a)
+	}
+
+	// 补丁节可以写在对应平台的节之前，读取完所有平台后再处理
+	for _, section := range patchSections {
+		fields := strings.Fields(section.Name())
+		n := len(fields)
+		i := index[fields[0]]
+		p, err := parsePatchSection(fields[n-1], section)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %v", section.Name(), err)
+		}
+
+		if n == 3 {
+			releases[i].Patches = append(releases[i].Patches, p)
+			continue
+		}
+
+		platform := strings.ToLower(strings.Join(fields[1:n-2], ""))
+		found := false
+		for j, a := range releases[i].Artifacts {
+			if a.Platform() == platform {
+				releases[i].Artifacts[j].Patches = append(releases[i].Artifacts[j].Patches, p)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("[%s]: 版本 %s 没有 %s 的更新包", section.Name(), fields[0], platform)
+		}
+	}
+
+	if len(releases) == 0 {
+		vi, err := parseVersionSection(cfg.Section(""))
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, vi)
+	}
+
+	return releases, nil
+}
+
+// parseVersionSection 读取一个版本条目
+func parseVersionSection(section *ini.Section) (vi VersionInfo, err error) {
+	vi.Version = section.Key("version").String()
+	vi.Channel = section.Key("channel").String()
+	vi.Filename = section.Key("filename").String()
+	vi.Size = section.Key("size").MustInt64(0)
+	vi.Digests, err = parseSectionDigests(section)
+	if err != nil {
+		return vi, err
+	}
+	vi.FullPackageURL = section.Key("fullpackage").String()
+	vi.Signature = section.Key("signature").String()
+	vi.Rollback = section.Key("rollback").MustBool(false)
+	vi.Mandatory = section.Key("mandatory").MustBool(false)
+	vi.MinVersion = section.Key("min_version").String()
+	vi.Notes = section.Key("notes").String()
+	vi.Files, err = parseFileListKeys(section)
+	if err != nil {
+		return vi, err
+	}
+
+	return vi, nil
+}
+
+// parseArtifactSection 读取 [<版本> <os>/<arch>[/<variant>]] 形式的节
+func parseArtifactSection(platform string, section *ini.Section) (a Artifact, err error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(platform)), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return a, fmt.Errorf("无效的平台标识: %q", platform)
+	}
+	a.OS, a.Arch = parts[0], parts[1]
+	if len(parts) == 3 {
+		a.Variant = parts[2]
+	}
+
+	a.Filename = section.Key("filename").String()
+	a.Size = section.Key("size").MustInt64(0)
+	a.Signature = section.Key("signature").String()
+	a.Digests, err = parseSectionDigests(section)
+	if err != nil {
+		return a, err
+	}
+	a.Files, err = parseFileListKeys(section)
+	if err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+// parseFileListKeys 读取 files= 和 files_digest=<算法>:<hex> 指定的文件清单
+func parseFileListKeys(section *ini.Section) (FileList, error) {
+	list := FileList{Filename: section.Key("files").String()}
+	if list.Filename == "" {
+		return list, nil
+	}
+	digests, err := parseDigests(nil, section.Key("files_digest").String())
+	if err != nil {
+		return list, fmt.Errorf("文件清单: %v", err)
+	}
+	list.Digests = digests
+	return list, nil
+}
+
+// parsePatchSection 读取 [<版本> [<os>/<arch>[/<variant>]] from <旧版本>] 形式的节
+func parsePatchSection(from string, section *ini.Section) (p Patch, err error) {
+	p.From = from
+	p.Filename = section.Key("filename").String()
+	p.Size = section.Key("size").MustInt64(0)
+	p.Digests, err = parseSectionDigests(section)
+	if err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// jsonManifest JSON 格式的版本信息
+//
+//	{
+//	  "releases": [{
+//	    "version": "1.2.0",
+//	    "channel": "stable",
+//	    "notes": "...",
+//	    "min_version": "1.0.0",
+//	    "mandatory": false,
+//	    "fullpackage": "https://example.com/full_installer_1.2.0.exe",
+//	    "artifacts": [{
+//	      "os": "windows", "arch": "amd64",
+//	      "filename": "update_1.2.0_windows_amd64.zip",
+//	      "size": 1048576,
+//	      "digests": {"sha256": "..."},
+//	      "signature": "...",
+//	      "files": {"filename": "files_1.2.0_windows_amd64.json", "digest": "sha256:..."},
+//	      "patches": [{
+//	        "from": "1.1.0",
+//	        "filename": "update_1.1.0_1.2.0_windows_amd64.bsdiff",
+//	        "size": 65536,
+//	        "digests": {"sha256": "..."}
+//	      }]
+//	    }]
+//	  }]
+//	}
+//
+// 不带 os/arch 的更新包适用于所有平台。
+type jsonManifest struct {
+	Releases []jsonRelease `json:"releases"`
+}
+
+type jsonRelease struct {
+	Version     string         `json:"version"`
+	Channel     string         `json:"channel"`
+	Notes       string         `json:"notes"`
+	MinVersion  string         `json:"min_version"`
+	Rollback    bool           `json:"rollback"`
+	Mandatory   bool           `json:"mandatory"`
+	FullPackage string         `json:"fullpackage"`
+	Artifacts   []jsonArtifact `json:"artifacts"`
+}
+
+type jsonArtifact struct {
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	Variant   string            `json:"variant"`
+	Filename  string            `json:"filename"`
+	Size      int64             `json:"size"`
+	Digests   map[string]string `json:"digests"`
+	Digest    string            `json:"digest"`
+	Signature string            `json:"signature"`
+	Patches   []jsonPatch       `json:"patches"`
+	Files     *jsonFileList     `json:"files"`
+}
+
+type jsonFileList struct {
+	Filename string            `json:"filename"`
+	Digests  map[string]string `json:"digests"`
+	Digest   string            `json:"digest"`
+}
+
+type jsonPatch struct {
+	From     string            `json:"from"`
+	Filename string            `json:"filename"`
+	Size     int64             `json:"size"`
+	Digests  map[string]string `json:"digests"`
+	Digest   string            `json:"digest"`
+}
+
+// decodeJSONManifest 读取 JSON 格式的版本信息
+func decodeJSONManifest(data []byte) ([]VersionInfo, error) {
+	var m jsonManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	var releases []VersionInfo
+	for _, r := range m.Releases {
+		vi := VersionInfo{
+			Version:        r.Version,
+			Channel:        r.Channel,
+			Notes:          r.Notes,
+			MinVersion:     r.MinVersion,
+			Rollback:       r.Rollback,
+			Mandatory:      r.Mandatory,
+			FullPackageURL: r.FullPackage,
+		}
+
+		for _, ja := range r.Artifacts {
+			digests, err := parseDigests(ja.Digests, ja.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("版本 %s: %v", r.Version, err)
+			}
+
+			var patches []Patch
+			for _, jp := range ja.Patches {
+				patchDigests, err := parseDigests(jp.Digests, jp.Digest)
+				if err != nil {
+					return nil, fmt.Errorf("版本 %s 从 %s 的补丁: %v", r.Version, jp.From, err)
+				}
+				patches = append(patches, Patch{From: jp.From, Filename: jp.Filename, Size: jp.Size, Digests: patchDigests})
+			}
+
+			var files FileList
+			if ja.Files != nil {
+				files.Filename = ja.Files.Filename
+				files.Digests, err = parseDigests(ja.Files.Digests, ja.Files.Digest)
+				if err != nil {
+					return nil, fmt.Errorf("版本 %s 的文件清单: %v", r.Version, err)
+				}
+			}
+
+			if ja.OS == "" && ja.Arch == "" && ja.Variant == "" {
+				if vi.Filename != "" {
+					return nil, fmt.Errorf("版本 %s 有多个通用更新包", r.Version)
+				}
+				vi.Filename, vi.Size, vi.Digests, vi.Signature = ja.Filename, ja.Size, digests, ja.Signature
+				vi.Patches, vi.Files = patches, files
+				continue
+			}
+
+			vi.Artifacts = append(vi.Artifacts, Artifact{
+				OS:        strings.ToLower(ja.OS),
+				Arch:      strings.ToLower(ja.Arch),
+				Variant:   strings.ToLower(ja.Variant),
+				Filename:  ja.Filename,
+				Size:      ja.Size,
+				Digests:   digests,
+				Signature: ja.Signature,
+				Patches:   patches,
+				Files:     files,
+			})
+		}
+
+		releases = append(releases, vi)
+	}
+
+	return releases, nil
+}
@@ -0,0 +1,196 @@
+package updater
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestManifestFormat(t *testing.T) {
+	tests := []struct {
+		data        string
+		contentType string
+		name        string
+		want        string
+	}{
+		{"", "application/json; charset=utf-8", "ver.ini", ManifestJSON},
+		{"", "application/vnd.app+json", "", ManifestJSON},
+		{"{}", "text/x-ini", "ver.json", ManifestINI},
+		{"", "", "https://example.com/ver.json?t=1", ManifestJSON},
+		{"", "text/plain", "https://example.com/ver.ini#a", ManifestINI},
+		{"  {\"releases\": []}", "text/plain", "ver", ManifestJSON},
+		{"version = 1.0.0", "", "", ManifestINI},
+	}
+	for _, tt := range tests {
+		if got := manifestFormat([]byte(tt.data), tt.contentType, tt.name); got != tt.want {
+			t.Errorf("manifestFormat(%q, %q, %q) = %s, want %s", tt.data, tt.contentType, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeLegacyINIManifest(t *testing.T) {
+	data := "version = 1.0.1\nfilename = update.zip\nsha256 = " + testSHA256 + "\nfullpackage = https://example.com/full.exe\n"
+
+	releases, err := decodeManifest([]byte(data), "", "ver.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 1 {
+		t.Fatalf("len(releases) = %d, want 1", len(releases))
+	}
+	vi := releases[0]
+	if vi.Version != "1.0.1" || vi.Channel != ChannelStable || vi.Filename != "update.zip" ||
+		vi.Digests[DigestSHA256] != testSHA256 || vi.FullPackageURL != "https://example.com/full.exe" {
+		t.Fatalf("release = %+v", vi)
+	}
+	if string(vi.RawData) != data {
+		t.Error("RawData 不是原始数据")
+	}
+}
+
+func TestDecodeINIManifest(t *testing.T) {
+	data := `
+[1.2.0]
+fullpackage = https://example.com/full.exe
+min_version = 1.0.0
+mandatory = true
+
+[1.2.0 linux/amd64 from 1.1.0]
+filename = update_1.1.0_1.2.0_linux_amd64.bsdiff
+sha256 = ` + testSHA256 + `
+
+[1.2.0 linux/amd64]
+filename = update_1.2.0_linux_amd64.zip
+size = 1024
+digest = sha512:` + testSHA512 + `
+files = files_1.2.0.ini
+files_digest = sha256:` + testSHA256 + `
+
+[1.2.0 Linux/AMD64/musl]
+filename = update_1.2.0_linux_amd64_musl.zip
+sha256 = ` + testSHA256 + `
+
+[1.3.0-beta.1]
+channel = beta
+fullpackage = https://example.com/full_beta.exe
+filename = update_1.3.0-beta.1.zip
+md5 = ` + testMD5 + `
+`
+	releases, err := decodeManifest([]byte(data), "", "ver.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 {
+		t.Fatalf("len(releases) = %d, want 2", len(releases))
+	}
+
+	stable := releases[0]
+	if stable.Version != "1.2.0" || stable.Channel != ChannelStable || !stable.Mandatory || stable.MinVersion != "1.0.0" {
+		t.Fatalf("release = %+v", stable)
+	}
+	if len(stable.Artifacts) != 2 {
+		t.Fatalf("len(Artifacts) = %d, want 2", len(stable.Artifacts))
+	}
+	a := stable.Artifacts[0]
+	if a.Platform() != "linux/amd64" || a.Size != 1024 || a.Digests[DigestSHA512] != testSHA512 {
+		t.Fatalf("artifact = %+v", a)
+	}
+	if len(a.Patches) != 1 || a.Patches[0].From != "1.1.0" || a.Patches[0].Digests[DigestSHA256] != testSHA256 {
+		t.Fatalf("patches = %+v", a.Patches)
+	}
+	if a.Files.Filename != "files_1.2.0.ini" || a.Files.Digests[DigestSHA256] != testSHA256 {
+		t.Fatalf("files = %+v", a.Files)
+	}
+	if got := stable.Artifacts[1].Platform(); got != "linux/amd64/musl" {
+		t.Fatalf("Platform() = %s, want linux/amd64/musl", got)
+	}
+
+	beta := releases[1]
+	if beta.Version != "1.3.0-beta.1" || beta.Channel != ChannelBeta || beta.Digests[DigestMD5] != testMD5 {
+		t.Fatalf("release = %+v", beta)
+	}
+}
+
+func TestDecodeJSONManifest(t *testing.T) {
+	data := `{
+  "releases": [{
+    "version": "1.2.0",
+    "channel": "Beta",
+    "notes": "修复问题",
+    "rollback": true,
+    "fullpackage": "https://example.com/full.exe",
+    "artifacts": [
+      {"filename": "update_1.2.0.zip", "digest": "sha256:` + testSHA256 + `", "signature": "c2ln"},
+      {"os": "Windows", "arch": "amd64", "filename": "update_1.2.0_windows.zip", "size": 10,
+       "digests": {"sha512": "` + testSHA512 + `"},
+       "files": {"filename": "files.json", "digests": {"sha256": "` + testSHA256 + `"}},
+       "patches": [{"from": "1.1.0", "filename": "p.bsdiff", "digests": {"sha256": "` + testSHA256 + `"}}]}
+    ]
+  }]
+}`
+	releases, err := decodeManifest([]byte(data), "text/plain", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 1 {
+		t.Fatalf("len(releases) = %d, want 1", len(releases))
+	}
+
+	vi := releases[0]
+	if vi.Channel != ChannelBeta || vi.Notes != "修复问题" || !vi.Rollback {
+		t.Fatalf("release = %+v", vi)
+	}
+	if vi.Filename != "update_1.2.0.zip" || vi.Digests[DigestSHA256] != testSHA256 || vi.Signature != "c2ln" {
+		t.Fatalf("通用更新包 = %+v", vi)
+	}
+	if len(vi.Artifacts) != 1 {
+		t.Fatalf("len(Artifacts) = %d, want 1", len(vi.Artifacts))
+	}
+	a := vi.Artifacts[0]
+	if a.Platform() != "windows/amd64" || a.Size != 10 || a.Digests[DigestSHA512] != testSHA512 ||
+		a.Files.Filename != "files.json" || len(a.Patches) != 1 || a.Patches[0].From != "1.1.0" {
+		t.Fatalf("artifact = %+v", a)
+	}
+}
+
+func TestDecodeManifestErrors(t *testing.T) {
+	full := "fullpackage = https://example.com/full.exe\n"
+	sha := "sha256 = " + testSHA256 + "\n"
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"空文件", ""},
+		{"无效的版本号", "version = 1.x\nfilename = a.zip\n" + sha + full},
+		{"缺少完整安装包", "version = 1.0.0\nfilename = a.zip\n" + sha},
+		{"没有更新包", "version = 1.0.0\n" + full},
+		{"缺少摘要", "version = 1.0.0\nfilename = a.zip\n" + full},
+		{"摘要格式错误", "version = 1.0.0\nfilename = a.zip\nsha256 = 1234\n" + full},
+		{"未知通道", "version = 1.0.0\nchannel = canary\nfilename = a.zip\n" + sha + full},
+		{"未定义的版本", "[1.0.0]\nfilename = a.zip\n" + sha + full + "[2.0.0 linux/amd64]\nfilename = b.zip\n" + sha},
+		{"无效的平台", "[1.0.0]\n" + full + "[1.0.0 linux]\nfilename = b.zip\n" + sha},
+		{"平台缺少摘要", "[1.0.0]\n" + full + "[1.0.0 linux/amd64]\nfilename = b.zip\n"},
+		{"补丁没有对应平台", "[1.0.0]\n" + full + "[1.0.0 linux/amd64]\nfilename = b.zip\n" + sha +
+			"[1.0.0 darwin/arm64 from 0.9.0]\nfilename = p.bsdiff\n" + sha},
+		{"补丁起始版本无效", "[1.0.0]\nfilename = a.zip\n" + sha + full + "[1.0.0 from old]\nfilename = p.bsdiff\n" + sha},
+		{"文件清单缺少摘要", "version = 1.0.0\nfilename = a.zip\nfiles = files.ini\n" + sha + full},
+		{"JSON 语法错误", `{"releases": [`},
+		{"JSON 没有版本", `{"releases": []}`},
+		{"JSON 多个通用更新包", `{"releases": [{"version": "1.0.0", "fullpackage": "x", "artifacts": [
+			{"filename": "a.zip", "digest": "sha256:` + testSHA256 + `"},
+			{"filename": "b.zip", "digest": "sha256:` + testSHA256 + `"}]}]}`},
+		{"JSON 更新包缺少架构", `{"releases": [{"version": "1.0.0", "fullpackage": "x", "artifacts": [
+			{"os": "linux", "filename": "a.zip", "digest": "sha256:` + testSHA256 + `"}]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			releases, err := decodeManifest([]byte(tt.data), "", "")
+			if err == nil {
+				t.Fatalf("decodeManifest() = %+v, want error", releases)
+			}
+			if !strings.HasPrefix(err.Error(), "无法解析版本信息") {
+				t.Fatalf("decodeManifest() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	MirrorStateFile = "mirrors.ini"
+
+	MirrorOrdered  = "ordered"
+	MirrorWeighted = "weighted"
+
+	DefaultMirrorName     = "default"
+	DefaultMirrorCooldown = 10 * time.Minute
+)
+
+// Mirror 一个更新源，版本信息和更新包各自使用独立的地址
+type Mirror struct {
+	Name string
+	// ManifestURL 版本信息地址，签名与公钥列表位于同一目录
+	ManifestURL string
+	// ReleaseURL 更新包地址模板，依次填入版本号和文件名
+	ReleaseURL string
+	// Weight weighted 模式下被优先选中的相对概率
+	Weight int
+}
+
+// KeyListURL 公钥列表地址，与版本信息位于同一目录
+func (m Mirror) KeyListURL() string {
+	base, err := url.Parse(m.ManifestURL)
+	if err != nil {
+		return KeyListFile
+	}
+	return base.ResolveReference(&url.URL{Path: KeyListFile}).String()
+}
+
+func (m Mirror) packageURL(version string, filename string) string {
+	return fmt.Sprintf(m.ReleaseURL, version, filename)
+}
+
+// mirrorHealth 记录最近失败的镜像，保存在可执行文件旁的 mirrors.ini 中
+//
+//	[https://ghp.ci]
+//	failed_at = 1700000000
+type mirrorHealth struct {
+	path string
+	// readOnly 只在内存中记录，不写入文件
+	readOnly bool
+
+	mu       sync.Mutex
+	failedAt map[string]time.Time
+}
+
+func loadMirrorHealth(path string) *mirrorHealth {
+	h := &mirrorHealth{path: path, failedAt: make(map[string]time.Time)}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	cfg, err := ini.LoadSources(ini.LoadOptions{ChildSectionDelimiter: "\x00"}, content)
+	if err != nil {
+		return h
+	}
+	for _, section := range cfg.Sections() {
+		if t := section.Key("failed_at").MustInt64(0); t > 0 {
+			h.failedAt[section.Name()] = time.Unix(t, 0)
+		}
+	}
+	return h
+}
+
+// recentFailure 镜像在 cooldown 内失败的时间，没有失败过时返回零值
+func (h *mirrorHealth) recentFailure(name string, now time.Time, cooldown time.Duration) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.failedAt[name]
+	if !ok || now.Sub(t) >= cooldown {
+		return time.Time{}
+	}
+	return t
+}
+
+func (h *mirrorHealth) markFailed(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failedAt[name] = time.Now()
+	h.saveLocked()
+}
+
+func (h *mirrorHealth) markOK(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.failedAt[name]; !ok {
+		return
+	}
+	delete(h.failedAt, name)
+	h.saveLocked()
+}
+
+// saveLocked 写入失败记录，写入失败只影响下次运行时的镜像顺序，忽略错误
+func (h *mirrorHealth) saveLocked() {
+	if h.readOnly {
+		return
+	}
+	if len(h.failedAt) == 0 {
+		os.Remove(h.path)
+		return
+	}
+
+	names := make([]string, 0, len(h.failedAt))
+	for name := range h.failedAt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "[%s]\nfailed_at = %d\n\n", name, h.failedAt[name].Unix())
+	}
+	ioutil.WriteFile(h.path, []byte(b.String()), 0644)
+}
+
+// orderMirrors 按配置的顺序或权重排列镜像
+func orderMirrors(mirrors []Mirror, policy string) []Mirror {
+	ordered := make([]Mirror, len(mirrors))
+	copy(ordered, mirrors)
+
+	if policy == MirrorWeighted {
+		// 按权重随机排列 (Efraimidis-Spirakis): key = u^(1/w)，key 越大越靠前
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		keys := make(map[string]float64, len(ordered))
+		for _, m := range ordered {
+			w := float64(m.Weight)
+			if w <= 0 {
+				keys[m.Name] = -1
+				continue
+			}
+			keys[m.Name] = math.Pow(r.Float64(), 1/w)
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return keys[ordered[i].Name] > keys[ordered[j].Name]
+		})
+	}
+	return ordered
+}
+
+// mirrorAttempts 每个请求的尝试次数，镜像较少时同一镜像会重试多次
+func mirrorAttempts(mirrors []Mirror, policy RetryPolicy) int {
+	if len(mirrors) > policy.Attempts {
+		return len(mirrors)
+	}
+	return policy.Attempts
+}
+
+// mirrors 当前的镜像顺序，最近失败过的镜像排在最后，其中失败越早越靠前
+//
+// 权重随机的顺序在一次运行中只计算一次，保证各请求的顺序一致。
+func (u *Updater) mirrors() []Mirror {
+	if u.mirrorOrder == nil {
+		u.mirrorOrder = orderMirrors(u.Config.MirrorList(), u.Config.MirrorOrder)
+	}
+
+	now := time.Now()
+	ordered := make([]Mirror, len(u.mirrorOrder))
+	failed := make(map[string]time.Time, len(u.mirrorOrder))
+	for i, m := range u.mirrorOrder {
+		ordered[i] = m
+		failed[m.Name] = u.health.recentFailure(m.Name, now, u.Config.MirrorCooldown)
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return failed[ordered[i].Name].Before(failed[ordered[j].Name])
+	})
+	return ordered
+}
+
+// tryMirrors 在当前最优先的镜像上执行 fn，失败后记录该镜像，按重试策略等待后换到下一个镜像重试
+//
+// fn 成功、ctx 取消或 final 认为错误不可重试时立即返回。
+func (u *Updater) tryMirrors(ctx context.Context, fn func(m Mirror) error, final func(err error) bool) error {
+	var err error
+	for attempt := 0; attempt < mirrorAttempts(u.mirrors(), u.Config.Retry); attempt++ {
+		if attempt > 0 {
+			if sleepErr := sleepContext(ctx, u.Config.Retry.retryDelay(attempt, err)); sleepErr != nil {
+				return sleepErr
+			}
+		}
+
+		mirror := u.mirrors()[0]
+		err = fn(mirror)
+		if err == nil {
+			u.health.markOK(mirror.Name)
+			return nil
+		}
+		if ctx.Err() != nil || (final != nil && final(err)) {
+			return err
+		}
+
+		if reason, ok := tlsFailureReason(err); ok {
+			u.log.Warn(fmt.Sprintf("更新源 %s 证书校验失败: %s", mirror.Name, reason), "mirror", mirror.Name)
+		}
+		u.log.Warn(fmt.Sprintf("更新源 %s 请求失败: %v", mirror.Name, err), "mirror", mirror.Name, "attempt", attempt+1)
+		u.health.markFailed(mirror.Name)
+	}
+	return err
+}
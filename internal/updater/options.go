@@ -0,0 +1,188 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// options New 的配置，读取安装目录之后才应用到 Updater
+type options struct {
+	appName     string
+	dir         string
+	channel     string
+	manifestURL string
+	trustedKeys *string
+	allowMD5    bool
+	events      func(Event)
+	logHandlers []slog.Handler
+	config      []func(c *Config)
+}
+
+// Option 配置 New 创建的更新程序
+type Option func(o *options)
+
+// WithAppName 设置应用名称
+func WithAppName(name string) Option {
+	return func(o *options) { o.appName = name }
+}
+
+// WithDir 设置安装目录，版本文件、updater.ini 和 TempDir 都位于其中
+//
+// 默认为可执行文件所在的目录。
+func WithDir(dir string) Option {
+	return func(o *options) { o.dir = dir }
+}
+
+// WithChannel 使用指定的发布通道，不保存到通道文件
+func WithChannel(name string) Option {
+	return func(o *options) { o.channel = name }
+}
+
+// WithManifestURL 替换 updater.ini 中的版本信息地址
+func WithManifestURL(url string) Option {
+	return func(o *options) { o.manifestURL = url }
+}
+
+// WithTrustedKeys 使用指定的根公钥代替编译时嵌入的 TrustedKeys，多个公钥以逗号分隔
+func WithTrustedKeys(keys string) Option {
+	return func(o *options) { o.trustedKeys = &keys }
+}
+
+// WithAllowMD5 允许只提供 MD5 摘要的版本信息
+func WithAllowMD5() Option {
+	return func(o *options) { o.allowMD5 = true }
+}
+
+// WithEvents 把进度、日志和状态变化作为事件交给 handler
+//
+// handler 不会被并发调用，但可能在不同的 goroutine 中调用，不应长时间阻塞。
+func WithEvents(handler func(Event)) Option {
+	return func(o *options) { o.events = handler }
+}
+
+// WithLogHandler 把日志同时交给 h，例如宿主程序自己的日志，级别由 h 自行过滤
+//
+// 日志文件和级别通过 WithConfig 修改 Config.Log 设置。
+func WithLogHandler(h slog.Handler) Option {
+	return func(o *options) { o.logHandlers = append(o.logHandlers, h) }
+}
+
+// WithConfig 在读取 updater.ini 之后修改配置，例如镜像、等待方式和健康检查
+func WithConfig(fn func(c *Config)) Option {
+	return func(o *options) { o.config = append(o.config, fn) }
+}
+
+// New 创建不显示界面、不询问确认的更新程序，供其他 Go 程序嵌入使用
+//
+// 不修改包级变量，同一进程中可以创建多个互不影响的实例，每个实例管理自己的安装目录。
+// 同一实例的方法不能并发调用。
+func New(opts ...Option) (*Updater, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	dir := o.dir
+	if dir == "" {
+		exepath, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Dir(exepath)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	u := newUpdater(dir, dir, NewEventUI(o.events))
+	u.AppName = o.appName
+	u.Silent = true
+	u.AllowMD5 = o.allowMD5
+	u.logHandlers = o.logHandlers
+	if o.trustedKeys != nil {
+		u.TrustedKeys = *o.trustedKeys
+	}
+
+	if u.configErr != nil {
+		return nil, u.configErr
+	}
+	if o.manifestURL != "" {
+		u.Config.ManifestURL = o.manifestURL
+	}
+	for _, fn := range o.config {
+		fn(&u.Config)
+	}
+	if o.channel != "" {
+		if err := u.UseChannel(o.channel); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := u.prepare(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Download 检查并下载新版本，校验后保存在 TempDir 中等待 Apply 安装
+//
+// 返回已下载的版本，没有新版本时返回空字符串。
+func (u *Updater) Download(ctx context.Context) (string, error) {
+	code := u.DownloadContext(ctx)
+	if code == ExitCodeStaged {
+		return u.NewVer.Version, nil
+	}
+	return "", u.runError(ctx, code)
+}
+
+// Apply 安装 Download 下载的版本，不访问网络
+//
+// 返回安装的版本，没有已下载的更新时返回空字符串。健康检查失败并回滚时返回错误。
+func (u *Updater) Apply(ctx context.Context) (string, error) {
+	before := u.CurrentVer.Version
+	code := u.InstallContext(ctx)
+	u.reloadVersion()
+
+	version := ""
+	if u.CurrentVer.Version != before {
+		version = u.CurrentVer.Version
+	}
+	return version, u.runError(ctx, code)
+}
+
+// Rollback 恢复保留的上一版本，返回恢复后的版本，没有保留上一版本时返回空字符串
+func (u *Updater) Rollback(ctx context.Context) (string, error) {
+	code := u.RollbackContext(ctx)
+	if code == ExitCodeRolledBack {
+		return u.CurrentVer.Version, nil
+	}
+	return "", u.runError(ctx, code)
+}
+
+// reloadVersion 重新读取安装后的本地版本
+func (u *Updater) reloadVersion() {
+	if current, err := ReadVersionFile(u.versionFilePath); err == nil {
+		u.CurrentVer = current
+	}
+}
+
+// runError 把退出码转换为错误，正常结束时返回 nil
+func (u *Updater) runError(ctx context.Context, code int) error {
+	switch code {
+	case ExitCodeNoUpdate, ExitCodeNewVersion, ExitCodeUpdateAvailable, ExitCodeStaged:
+		return nil
+	case ExitCodeCancel:
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return context.Canceled
+	}
+	if u.lastErr != nil {
+		return u.lastErr
+	}
+	return errors.New(exitStatus(code))
+}
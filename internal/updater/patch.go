@@ -0,0 +1,263 @@
+package updater
+
+import (
+	"compress/bzip2"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	bsdiffMagic      = "BSDIFF40"
+	bsdiffHeaderSize = 32
+	// patchChunkSize 应用补丁时每次读写的字节数
+	patchChunkSize = 64 * 1024
+)
+
+var errInvalidPatch = errors.New("无效的补丁文件")
+
+// Patch 从旧版本 From 的完整更新包生成本版本更新包的 bsdiff 补丁
+type Patch struct {
+	From     string
+	Filename string
+	Size     int64
+	Digests  map[string]string
+}
+
+// selectPatch 找出适用于当前版本的补丁，并确认本地保留的旧版本更新包完好
+func (u *Updater) selectPatch() (Patch, string, bool) {
+	if u.CurrentVer.Filename == "" {
+		return Patch{}, "", false
+	}
+	base := u.tempPath(InstalledDir, u.CurrentVer.Filename)
+
+	for _, p := range u.NewVer.Patches {
+		if c, err := CompareVersions(p.From, u.CurrentVer.Version); err != nil || c != 0 {
+			continue
+		}
+
+		digest, err := strongestDigest(u.CurrentVer.Digests, u.AllowMD5)
+		if err != nil {
+			return Patch{}, "", false
+		}
+		h := digest.newHash()
+		if err := hashFile(base, h); err != nil {
+			return Patch{}, "", false
+		}
+		if err := digest.Verify(h); err != nil {
+			u.log.Warn("本地保留的旧版本更新包已损坏，不能使用增量更新", "file", base)
+			return Patch{}, "", false
+		}
+		return p, base, true
+	}
+
+	return Patch{}, "", false
+}
+
+// downloadPatched 下载补丁并应用到旧版本更新包，结果通过 digest 校验后写入 target
+func (u *Updater) downloadPatched(ctx context.Context, patch Patch, base string, target string, digest Digest) error {
+	patchDigest, err := strongestDigest(patch.Digests, u.AllowMD5)
+	if err != nil {
+		return err
+	}
+
+	patchPath := u.tempPath(patch.Filename)
+	h := patchDigest.newHash()
+	err = u.download(ctx, downloadTarget{Filename: patch.Filename, Size: patch.Size, Digest: patchDigest}, patchPath, h)
+	if err != nil {
+		return fmt.Errorf("下载补丁失败: %v", err)
+	}
+	if err := patchDigest.Verify(h); err != nil {
+		discardPartial(patchPath)
+		return fmt.Errorf("补丁校验失败: %v", err)
+	}
+	defer os.Remove(patchPath)
+
+	// 先写入暂存文件，校验通过后才作为更新包使用
+	staged := target + ".patched"
+	if err := applyPatch(base, patchPath, staged, digest, u.Config.ArchiveLimits.MaxUnpackedSize); err != nil {
+		os.Remove(staged)
+		return err
+	}
+
+	discardPartial(target)
+	return os.Rename(staged, target)
+}
+
+// applyPatch 把补丁应用到 base，结果写入 staged 并通过 digest 校验
+func applyPatch(base string, patchPath string, staged string, digest Digest, maxSize int64) error {
+	oldFile, err := os.Open(base)
+	if err != nil {
+		return err
+	}
+	defer oldFile.Close()
+	oldInfo, err := oldFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	patchFile, err := os.Open(patchPath)
+	if err != nil {
+		return err
+	}
+	defer patchFile.Close()
+	patchInfo, err := patchFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(staged)
+	if err != nil {
+		return err
+	}
+	result := digest.newHash()
+	err = bspatch(io.NewSectionReader(oldFile, 0, oldInfo.Size()), io.NewSectionReader(patchFile, 0, patchInfo.Size()),
+		io.MultiWriter(out, result), maxSize)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := digest.Verify(result); err != nil {
+		return fmt.Errorf("应用补丁后的文件校验失败: %v", err)
+	}
+	return nil
+}
+
+// keepPackage 保留刚安装的完整更新包，替换之前保留的版本
+func (u *Updater) keepPackage(path string, filename string) error {
+	dir := u.tempPath(InstalledDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(dir, filename))
+}
+
+// bspatch 应用 BSDIFF40 格式的补丁，把生成的新文件写入 w
+//
+// 文件头: "BSDIFF40"、控制块长度、差异块长度、新文件长度（各 8 字节），之后是三个 bzip2 压缩块。
+// 控制块由 (x, y, z) 三元组组成: 把差异块的 x 字节与旧文件相加，再复制附加块的 y 字节，然后旧文件位置移动 z。
+// 旧文件和补丁按需读取，新文件分块写出，内存占用与文件大小无关。
+func bspatch(old *io.SectionReader, patch *io.SectionReader, w io.Writer, maxSize int64) error {
+	header := make([]byte, bsdiffHeaderSize)
+	if _, err := patch.ReadAt(header, 0); err != nil || string(header[:8]) != bsdiffMagic {
+		return errInvalidPatch
+	}
+
+	patchSize := patch.Size()
+	ctrlLen := offtin(header[8:16])
+	diffLen := offtin(header[16:24])
+	newSize := offtin(header[24:32])
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 ||
+		ctrlLen > patchSize || diffLen > patchSize ||
+		bsdiffHeaderSize+ctrlLen+diffLen > patchSize {
+		return errInvalidPatch
+	}
+	if maxSize > 0 && newSize > maxSize {
+		return fmt.Errorf("补丁生成的文件超过大小限制: %d", newSize)
+	}
+
+	ctrl := bzip2.NewReader(io.NewSectionReader(patch, bsdiffHeaderSize, ctrlLen))
+	diff := bzip2.NewReader(io.NewSectionReader(patch, bsdiffHeaderSize+ctrlLen, diffLen))
+	extra := bzip2.NewReader(io.NewSectionReader(patch, bsdiffHeaderSize+ctrlLen+diffLen, patchSize-bsdiffHeaderSize-ctrlLen-diffLen))
+
+	var oldPos, newPos int64
+	buf := make([]byte, patchChunkSize)
+	oldBuf := make([]byte, patchChunkSize)
+	num := make([]byte, 8)
+
+	for newPos < newSize {
+		var c [3]int64
+		for i := range c {
+			if _, err := io.ReadFull(ctrl, num); err != nil {
+				return fmt.Errorf("%w: %v", errInvalidPatch, err)
+			}
+			c[i] = offtin(num)
+		}
+
+		if c[0] < 0 || c[1] < 0 || c[0] > newSize-newPos {
+			return errInvalidPatch
+		}
+		for remaining := c[0]; remaining > 0; {
+			n := int64(len(buf))
+			if remaining < n {
+				n = remaining
+			}
+			if _, err := io.ReadFull(diff, buf[:n]); err != nil {
+				return fmt.Errorf("%w: %v", errInvalidPatch, err)
+			}
+			if err := readOld(old, oldPos, oldBuf[:n]); err != nil {
+				return err
+			}
+			for i := range buf[:n] {
+				buf[i] += oldBuf[i]
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			remaining -= n
+			newPos += n
+			oldPos += n
+		}
+
+		if c[1] > newSize-newPos {
+			return errInvalidPatch
+		}
+		for remaining := c[1]; remaining > 0; {
+			n := int64(len(buf))
+			if remaining < n {
+				n = remaining
+			}
+			if _, err := io.ReadFull(extra, buf[:n]); err != nil {
+				return fmt.Errorf("%w: %v", errInvalidPatch, err)
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			remaining -= n
+			newPos += n
+		}
+		oldPos += c[2]
+	}
+
+	return nil
+}
+
+// readOld 读取旧文件从 pos 开始的 len(buf) 字节，超出旧文件范围的部分视为 0
+func readOld(old *io.SectionReader, pos int64, buf []byte) error {
+	for i := range buf {
+		buf[i] = 0
+	}
+	start, end := pos, pos+int64(len(buf))
+	if start < 0 {
+		start = 0
+	}
+	if end > old.Size() {
+		end = old.Size()
+	}
+	if start >= end {
+		return nil
+	}
+	if _, err := old.ReadAt(buf[start-pos:end-pos], start); err != nil && err != io.EOF {
+		return fmt.Errorf("读取旧版本更新包失败: %v", err)
+	}
+	return nil
+}
+
+// offtin 读取 bsdiff 的 8 字节整数: 小端，最高位为符号位
+func offtin(b []byte) int64 {
+	y := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		y = -y
+	}
+	return y
+}
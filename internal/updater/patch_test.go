@@ -0,0 +1,143 @@
+package updater
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 由 bzip2 压缩生成的 BSDIFF40 补丁，Go 标准库只能解压 bzip2
+const (
+	// smallPatch 三个控制三元组，依次覆盖跳过旧文件、向前移动到旧文件开头之前、读取旧文件末尾之后
+	smallPatch = "QlNESUZGNDA6AAAAAAAAAEUAAAAAAAAANgAAAAAAAABCWmg5MUFZJlNZTnxinwAAD2hAXRhIQBAAQAAgADEDQNAaRjUzKI2VpEQMC6+S38XckU4UJBOfGKfAQlpoOTFBWSZTWVQXGuMAAAB1jMAEAAFgAAIgKAAUQAAgAAQADCAAMUwAE0KaGgbJoGuW2OIH7mSspULuSKcKEgqC41xgQlpoOTFBWSZTWb9pk8IAAAGRgEAABgAQACAAIZpoM00MvF3JFOFCQv2mTwg="
+	// largePatch 200000 字节的旧文件，每 1000 字节修改一处，并在 100000 处插入 10 字节
+	largePatch = "QlNESUZGNDA1AAAAAAAAADQAAAAAAAAASg0DAAAAAABCWmg5MUFZJlNZ0gJZtgAACmBQYBggAAEAQAAgADEMAJU/SmaMZ4NGJS3hdyRThQkNICWbYEJaaDkxQVkmU1lFmob+AAajwAHgAAEAAAggADDNNApQcKRoi0FI4RYCLxdyRThQkEWahv5CWmg5MUFZJlNZlrDk4AAAAAgAf+AgACIBpphADBVeaOPpi7kinChIS1hycAA="
+)
+
+func sectionOf(b []byte) *io.SectionReader {
+	return io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b)))
+}
+
+func decodeTestPatch(t *testing.T, s string) []byte {
+	t.Helper()
+	patch, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return patch
+}
+
+func TestBspatch(t *testing.T) {
+	old := []byte("The quick brown fox jumps over the lazy dog.")
+	want := []byte("The quick red fox jumps over the lazy cat! Extra tail.")
+
+	var out bytes.Buffer
+	if err := bspatch(sectionOf(old), sectionOf(decodeTestPatch(t, smallPatch)), &out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("bspatch() = %q, want %q", out.Bytes(), want)
+	}
+}
+
+func TestBspatchLarge(t *testing.T) {
+	const n = 200000
+	old := make([]byte, n)
+	for i := range old {
+		old[i] = byte(i * 7 % 251)
+	}
+	modified := append([]byte{}, old...)
+	for i := 0; i < n; i += 1000 {
+		modified[i]++
+	}
+	want := append(append(append([]byte{}, modified[:100000]...), "0123456789"...), modified[100000:]...)
+
+	var out bytes.Buffer
+	if err := bspatch(sectionOf(old), sectionOf(decodeTestPatch(t, largePatch)), &out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("bspatch() 生成 %d 字节，与期望的 %d 字节不同", out.Len(), len(want))
+	}
+}
+
+func TestBspatchInvalid(t *testing.T) {
+	old := []byte("The quick brown fox jumps over the lazy dog.")
+	patch := decodeTestPatch(t, smallPatch)
+
+	badMagic := append([]byte("BSDIFF41"), patch[8:]...)
+
+	// 声明的新文件长度比控制块描述的更长
+	longer := append([]byte{}, patch...)
+	binary.LittleEndian.PutUint64(longer[24:32], 1000)
+
+	// 控制块长度超出补丁文件
+	badLen := append([]byte{}, patch...)
+	binary.LittleEndian.PutUint64(badLen[8:16], uint64(len(patch)))
+
+	tests := []struct {
+		name  string
+		patch []byte
+	}{
+		{"空补丁", nil},
+		{"文件头不完整", patch[:20]},
+		{"错误的标识", badMagic},
+		{"补丁被截断", patch[:len(patch)/2]},
+		{"新文件长度不符", longer},
+		{"块长度超出文件", badLen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bspatch(sectionOf(old), sectionOf(tt.patch), io.Discard, 0)
+			if !errors.Is(err, errInvalidPatch) {
+				t.Fatalf("bspatch() = %v, want %v", err, errInvalidPatch)
+			}
+		})
+	}
+}
+
+func TestBspatchMaxSize(t *testing.T) {
+	old := []byte("The quick brown fox jumps over the lazy dog.")
+	err := bspatch(sectionOf(old), sectionOf(decodeTestPatch(t, smallPatch)), io.Discard, 10)
+	if err == nil || errors.Is(err, errInvalidPatch) {
+		t.Fatalf("bspatch() = %v, want size limit error", err)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "old.zip")
+	patchPath := filepath.Join(dir, "update.bsdiff")
+	staged := filepath.Join(dir, "new.zip.patched")
+	if err := os.WriteFile(base, []byte("The quick brown fox jumps over the lazy dog."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(patchPath, decodeTestPatch(t, smallPatch), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("The quick red fox jumps over the lazy cat! Extra tail.")
+	sum := sha256.Sum256(want)
+	digest := Digest{Algorithm: DigestSHA256, Value: hex.EncodeToString(sum[:])}
+	if err := applyPatch(base, patchPath, staged, digest, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("patched = %q, want %q", got, want)
+	}
+
+	if err := applyPatch(base, patchPath, staged, Digest{Algorithm: DigestSHA256, Value: testSHA256}, 0); err == nil {
+		t.Fatal("applyPatch() 没有发现摘要不匹配")
+	}
+}
@@ -0,0 +1,95 @@
+package updater
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Artifact 某个平台的更新包
+type Artifact struct {
+	OS      string
+	Arch    string
+	Variant string
+
+	Filename  string
+	Size      int64
+	Digests   map[string]string
+	Signature string
+	// Patches 从旧版本更新包生成本更新包的补丁
+	Patches []Patch
+	// Files 更新包内容的文件清单，用于只下载变化的文件
+	Files FileList
+}
+
+// Platform 返回 "os/arch[/variant]" 形式的平台标识
+func (a Artifact) Platform() string {
+	return platformString(a.OS, a.Arch, a.Variant)
+}
+
+func platformString(goos, goarch, variant string) string {
+	s := goos + "/" + goarch
+	if variant != "" {
+		s += "/" + variant
+	}
+	return s
+}
+
+// resolveReleases 为当前平台解析每个版本的更新包，丢弃没有适用更新包的版本
+func resolveReleases(releases []VersionInfo, goos, goarch, variant string) []VersionInfo {
+	var resolved []VersionInfo
+	for _, release := range releases {
+		if vi, err := resolveArtifact(release, goos, goarch, variant); err == nil {
+			resolved = append(resolved, vi)
+		}
+	}
+	return resolved
+}
+
+// resolveArtifact 为当前平台选出更新包，并填入 vi 的 Filename、Digests、Signature、Patches 和 Files
+//
+// 没有指定变体时优先使用不带变体的更新包；版本没有列出任何平台时使用通用更新包。
+func resolveArtifact(vi VersionInfo, goos, goarch, variant string) (VersionInfo, error) {
+	variant = strings.ToLower(strings.TrimSpace(variant))
+
+	var candidates []Artifact
+	for _, a := range vi.Artifacts {
+		if a.OS == goos && a.Arch == goarch {
+			candidates = append(candidates, a)
+		}
+	}
+
+	if len(candidates) == 0 {
+		if vi.Filename != "" && variant == "" {
+			return vi, nil
+		}
+		return vi, fmt.Errorf("版本 %s 没有适用于 %s 的更新包", vi.Version, platformString(goos, goarch, variant))
+	}
+
+	var matches []Artifact
+	for _, a := range candidates {
+		if a.Variant == variant {
+			matches = append(matches, a)
+		}
+	}
+
+	switch {
+	case len(matches) == 1:
+		a := matches[0]
+		vi.Filename, vi.Size, vi.Digests, vi.Signature = a.Filename, a.Size, a.Digests, a.Signature
+		vi.Patches, vi.Files = a.Patches, a.Files
+		return vi, nil
+	case len(matches) > 1:
+		return vi, fmt.Errorf("版本 %s 中 %s 的更新包重复", vi.Version, platformString(goos, goarch, variant))
+	case variant != "":
+		return vi, fmt.Errorf("版本 %s 没有适用于 %s 的更新包", vi.Version, platformString(goos, goarch, variant))
+	}
+
+	var variants []string
+	for _, a := range candidates {
+		variants = append(variants, a.Variant)
+	}
+	sort.Strings(variants)
+	return vi, fmt.Errorf("版本 %s 的 %s/%s 更新包需要指定变体 (%s)，请使用 -variant",
+		vi.Version, goos, goarch, strings.Join(variants, ", "))
+}
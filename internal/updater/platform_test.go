@@ -0,0 +1,38 @@
+package updater
+
+import "testing"
+
+func TestResolveReleasesSkipsOtherPlatforms(t *testing.T) {
+	releases := []VersionInfo{
+		{Version: "1.1.0", Channel: ChannelStable, Artifacts: []Artifact{
+			{OS: "linux", Arch: "amd64", Filename: "linux.zip"},
+			{OS: "windows", Arch: "amd64", Filename: "windows.zip"},
+		}},
+		{Version: "1.2.0", Channel: ChannelStable, Artifacts: []Artifact{
+			{OS: "windows", Arch: "amd64", Filename: "windows.zip"},
+		}},
+		{Version: "1.3.0", Channel: ChannelStable, Artifacts: []Artifact{
+			{OS: "linux", Arch: "amd64", Variant: "musl", Filename: "musl.zip"},
+		}},
+	}
+
+	latest, err := selectRelease(resolveReleases(releases, "linux", "amd64", ""), ChannelStable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != "1.1.0" || latest.Filename != "linux.zip" {
+		t.Fatalf("latest = %s %s, want 1.1.0 linux.zip", latest.Version, latest.Filename)
+	}
+
+	latest, err = selectRelease(resolveReleases(releases, "linux", "amd64", "musl"), ChannelStable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != "1.3.0" || latest.Filename != "musl.zip" {
+		t.Fatalf("latest = %s %s, want 1.3.0 musl.zip", latest.Version, latest.Filename)
+	}
+
+	if _, err := selectRelease(resolveReleases(releases, "darwin", "arm64", ""), ChannelStable); err == nil {
+		t.Fatal("selectRelease() 没有返回错误")
+	}
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package updater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// processRunning 进程是否存在，没有权限发送信号的进程同样视为存在
+//
+// 已退出但还没有被父进程回收的僵尸进程不再占用文件，视为已退出。
+func processRunning(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	// /proc/<pid>/stat: "<pid> (<comm>) <state> ..."，comm 中可能包含括号
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	s := string(stat)
+	if i := strings.LastIndex(s, ")"); i >= 0 && i+2 < len(s) {
+		return s[i+2] != 'Z'
+	}
+	return true
+}
+
+// terminateProcess 发送 SIGTERM 请求进程退出
+func terminateProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+// detachedProcAttr 在新的会话中启动程序，不随更新程序或终端退出
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// lockHeld 其他进程是否通过 flock 持有锁文件，或锁文件中记录的进程仍在运行
+func lockHeld(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return err == syscall.EWOULDBLOCK
+	}
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	pid := lockFilePID(path)
+	return pid > 0 && processRunning(pid)
+}
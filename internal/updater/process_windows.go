@@ -0,0 +1,54 @@
+//go:build windows
+// +build windows
+
+package updater
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const (
+	// errorSharingViolation 文件已被其他进程以不允许共享的方式打开
+	errorSharingViolation syscall.Errno = 32
+	// detachedProcess 新进程不继承控制台
+	detachedProcess = 0x00000008
+)
+
+// processRunning 进程是否存在且没有退出，没有权限打开的进程同样视为存在
+func processRunning(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.SYNCHRONIZE, false, uint32(pid))
+	if err != nil {
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+
+	event, err := syscall.WaitForSingleObject(h, 0)
+	return err == nil && event == syscall.WAIT_TIMEOUT
+}
+
+// terminateProcess Windows 没有 SIGTERM，配置检查阶段已拒绝 signal 方式
+func terminateProcess(pid int) error {
+	return fmt.Errorf("Windows 不支持通过信号关闭程序")
+}
+
+// detachedProcAttr 启动与更新程序无关的进程，不随更新程序退出
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
+
+// lockHeld 其他进程是否独占打开了锁文件，或锁文件中记录的进程仍在运行
+func lockHeld(path string) bool {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ, 0, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return err == errorSharingViolation
+	}
+	syscall.CloseHandle(h)
+
+	pid := lockFilePID(path)
+	return pid > 0 && processRunning(pid)
+}
@@ -0,0 +1,68 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// DefaultRelaunchGrace 重新启动后观察程序的时间
+const DefaultRelaunchGrace = 5 * time.Second
+
+// ErrRelaunch 更新后的程序没有正常启动
+var ErrRelaunch = errors.New("程序启动失败")
+
+// Relaunch 更新成功后重新启动的程序
+type Relaunch struct {
+	// Args 程序路径和参数，通常是宿主程序启动时的原始命令行
+	Args []string
+	// Dir 工作目录，为空时使用更新程序的当前目录；Args[0] 为相对路径时相对于该目录
+	Dir string
+	// Grace 启动后观察的时间，期间以非 0 退出码退出视为启动失败
+	Grace time.Duration
+}
+
+// relaunch 在独立的会话中启动程序，确认它在 Grace 内没有异常退出
+//
+// 更新程序退出后被启动的程序继续运行。程序仍在运行时返回它的进程，
+// 健康检查失败时用于结束程序。
+func (u *Updater) relaunch(ctx context.Context) (*os.Process, error) {
+	r := u.Relaunch
+	if len(r.Args) == 0 {
+		return nil, fmt.Errorf("%w: 没有指定要启动的程序", ErrRelaunch)
+	}
+
+	cmd := exec.Command(r.Args[0], r.Args[1:]...)
+	cmd.Dir = r.Dir
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRelaunch, err)
+	}
+	u.log.Info(fmt.Sprintf("启动程序: %s (PID %d)", r.Args[0], cmd.Process.Pid), "program", r.Args[0], "pid", cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(r.Grace)
+	defer timer.Stop()
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRelaunch, err)
+		}
+		// 启动器一类的程序可能很快正常退出
+		u.log.Info("程序已启动并正常退出")
+		return nil, nil
+	case <-timer.C:
+		u.log.Info("程序已启动")
+	case <-ctx.Done():
+		// 不再观察，程序继续运行
+	}
+	return cmd.Process, nil
+}
@@ -0,0 +1,127 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInitialDelay = time.Second
+	DefaultMaxDelay     = 30 * time.Second
+	DefaultMultiplier   = 2.0
+
+	// MaxRetryAfter 服务器通过 Retry-After 要求的等待时间上限
+	MaxRetryAfter = 5 * time.Minute
+)
+
+// RetryPolicy 重试策略，等待时间按指数增长并加入随机抖动
+//
+//	[retry]
+//	attempts      = 4
+//	initial_delay = 1s
+//	max_delay     = 30s
+//	multiplier    = 2
+type RetryPolicy struct {
+	// Attempts 每个请求最多尝试的次数（镜像较多时至少每个镜像一次）
+	Attempts     int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+}
+
+// DefaultRetryPolicy 默认重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Attempts:     RetryLimit,
+		InitialDelay: DefaultInitialDelay,
+		MaxDelay:     DefaultMaxDelay,
+		Multiplier:   DefaultMultiplier,
+	}
+}
+
+// Validate 检查重试策略是否可用
+func (p RetryPolicy) Validate() error {
+	if p.Attempts < 1 {
+		return fmt.Errorf("重试次数至少为 1: %d", p.Attempts)
+	}
+	if p.InitialDelay <= 0 || p.MaxDelay < p.InitialDelay {
+		return fmt.Errorf("无效的重试间隔: %v - %v", p.InitialDelay, p.MaxDelay)
+	}
+	if p.Multiplier < 1 {
+		return fmt.Errorf("重试间隔倍数不能小于 1: %v", p.Multiplier)
+	}
+	return nil
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// delay 第 n 次重试（从 1 开始）前的等待时间
+//
+// 基准时间为 InitialDelay * Multiplier^(n-1)，不超过 MaxDelay，实际等待时间在基准的一半到全部之间随机，
+// 避免大量客户端在同一时刻重试。
+func (p RetryPolicy) delay(n int) time.Duration {
+	base := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(n-1))
+	if base > float64(p.MaxDelay) {
+		base = float64(p.MaxDelay)
+	}
+
+	jitterMu.Lock()
+	r := jitterRand.Float64()
+	jitterMu.Unlock()
+
+	return time.Duration(base/2 + r*base/2)
+}
+
+// retryDelay 等待时间取退避时间与服务器 Retry-After 中较长的一个
+func (p RetryPolicy) retryDelay(n int, err error) time.Duration {
+	d := p.delay(n)
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > d {
+		d = statusErr.RetryAfter
+		if d > MaxRetryAfter {
+			d = MaxRetryAfter
+		}
+	}
+	return d
+}
+
+// parseRetryAfter 解析秒数或 HTTP 日期形式的 Retry-After，无法解析时返回 0
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// sleepContext 等待 d，ctx 取消时提前返回 ctx 的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,287 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// PreviousDir 上一版本的备份目录，位于 TempDir 下
+	PreviousDir = "previous"
+	// PreviousRecordFile 上一版本的安装记录，位于 PreviousDir 中
+	PreviousRecordFile = "rollback.ini"
+	// FailedVersionsFile 健康检查失败并已回滚的版本，保存在本地版本文件旁
+	FailedVersionsFile = "failed.ini"
+
+	previousFilesDir = "files"
+)
+
+// ErrNoPrevious 没有可以回滚到的上一版本
+var ErrNoPrevious = errors.New("没有保留上一版本")
+
+// previousStep 安装时的一个步骤，回滚时按相反顺序撤销
+type previousStep struct {
+	// path 相对于安装目录、使用 "/" 分隔的路径
+	path string
+	// backup 文件原本存在，备份在 files 目录中
+	backup bool
+	// dir 安装时新建的目录
+	dir bool
+}
+
+func (u *Updater) previousDir() string {
+	return u.tempPath(PreviousDir)
+}
+
+// stagePrevious 在写入新版本文件之前复制当前的版本文件和文件清单
+//
+// 返回的目录在 keepPrevious 中替换之前保留的版本，安装失败时由调用方删除。
+func (u *Updater) stagePrevious() (string, error) {
+	staging := u.previousDir() + ".new"
+	if err := os.RemoveAll(staging); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return "", err
+	}
+
+	if err := copyIfExists(u.versionFilePath, filepath.Join(staging, VersionFile)); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	if err := copyIfExists(u.installedFilesPath(), filepath.Join(staging, InstalledFilesFile)); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	return staging, nil
+}
+
+// keepPrevious 把事务的备份移动到 staging 并写入安装记录，然后替换之前保留的版本
+//
+//	version     = 1.1.0
+//	replaced_by = 1.2.0
+//
+//	[bin/app]
+//	backup = true
+//
+//	[lib]
+//	dir = true
+func (u *Updater) keepPrevious(staging string, tx *installTransaction) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "version = %s\nreplaced_by = %s\n\n", u.CurrentVer.Version, u.NewVer.Version)
+
+	for _, step := range tx.steps {
+		rel, err := filepath.Rel(tx.root, step.target)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "[%s]\nbackup = %t\n\n", filepath.ToSlash(rel), step.backup != "")
+	}
+	for _, dir := range tx.dirs {
+		rel, err := filepath.Rel(tx.root, dir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "[%s]\ndir = true\n\n", filepath.ToSlash(rel))
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(staging, PreviousRecordFile), []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tx.backupDir, filepath.Join(staging, previousFilesDir)); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(u.previousDir()); err != nil {
+		return err
+	}
+	return os.Rename(staging, u.previousDir())
+}
+
+// readPrevious 读取上一版本的安装记录
+func (u *Updater) readPrevious() (string, []previousStep, error) {
+	content, err := ioutil.ReadFile(filepath.Join(u.previousDir(), PreviousRecordFile))
+	if os.IsNotExist(err) {
+		return "", nil, ErrNoPrevious
+	} else if err != nil {
+		return "", nil, err
+	}
+	cfg, err := ini.LoadSources(ini.LoadOptions{ChildSectionDelimiter: "\x00"}, content)
+	if err != nil {
+		return "", nil, fmt.Errorf("无法解析回滚记录: %v", err)
+	}
+
+	var steps []previousStep
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+		steps = append(steps, previousStep{
+			path:   section.Name(),
+			backup: section.Key("backup").MustBool(false),
+			dir:    section.Key("dir").MustBool(false),
+		})
+	}
+	return cfg.Section("").Key("version").String(), steps, nil
+}
+
+// rollbackPrevious 恢复保留的上一版本，返回恢复后的版本号
+//
+// 已经恢复的文件会被跳过，中途失败后可以再次执行。
+func (u *Updater) rollbackPrevious() (string, error) {
+	version, steps, err := u.readPrevious()
+	if err != nil {
+		return "", err
+	}
+
+	root := u.dir
+	backupDir := filepath.Join(u.previousDir(), previousFilesDir)
+
+	var errs []string
+	var dirs []string
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		target, err := containedPath(root, step.path)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if step.dir {
+			dirs = append(dirs, target)
+			continue
+		}
+
+		if !step.backup {
+			if err := os.RemoveAll(target); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+
+		backup := filepath.Join(backupDir, filepath.FromSlash(step.path))
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.RemoveAll(target); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := os.Rename(backup, target); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	// 先删除深层目录，目录中还有其他文件时保留
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+
+	if err := restoreOrRemove(filepath.Join(u.previousDir(), VersionFile), u.versionFilePath); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := restoreOrRemove(filepath.Join(u.previousDir(), InstalledFilesFile), u.installedFilesPath()); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return version, fmt.Errorf("回滚失败: %s", strings.Join(errs, "; "))
+	}
+
+	os.RemoveAll(u.previousDir())
+	// 保留的更新包属于被回滚的版本，不能再作为补丁的基础
+	os.RemoveAll(u.tempPath(InstalledDir))
+	return version, nil
+}
+
+// restoreOrRemove 用 saved 替换 target；没有保存 saved 时说明 target 原本不存在
+func restoreOrRemove(saved string, target string) error {
+	if _, err := os.Stat(saved); os.IsNotExist(err) {
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.Rename(saved, target)
+}
+
+// copyIfExists 复制文件，源文件不存在时什么也不做
+func copyIfExists(src string, dst string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (u *Updater) failedVersionsPath() string {
+	return filepath.Join(filepath.Dir(u.versionFilePath), FailedVersionsFile)
+}
+
+// recordFailedVersion 记录被回滚的版本，之后不再安装
+//
+//	[1.2.0]
+//	failed_at = 1700000000
+//	reason    = ...
+func (u *Updater) recordFailedVersion(version string, reason string) error {
+	path := u.failedVersionsPath()
+
+	cfg, err := ini.LoadSources(ini.LoadOptions{ChildSectionDelimiter: "\x00", Loose: true}, path)
+	if err != nil {
+		return err
+	}
+	section := cfg.Section(version)
+	section.Key("failed_at").SetValue(fmt.Sprint(time.Now().Unix()))
+	section.Key("reason").SetValue(reason)
+	return cfg.SaveTo(path)
+}
+
+// versionFailed 版本是否曾经没有通过健康检查或被手动回滚
+func (u *Updater) versionFailed(version string) bool {
+	for _, v := range u.failedVersions() {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// failedVersions 记录在 failed.ini 中、不再安装的版本
+func (u *Updater) failedVersions() []string {
+	cfg, err := ini.LoadSources(ini.LoadOptions{ChildSectionDelimiter: "\x00", Loose: true}, u.failedVersionsPath())
+	if err != nil {
+		return nil
+	}
+	var versions []string
+	for _, section := range cfg.Sections() {
+		if section.Name() != ini.DefaultSection {
+			versions = append(versions, section.Name())
+		}
+	}
+	return versions
+}
@@ -0,0 +1,173 @@
+package updater
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version 语义化版本 (https://semver.org)
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	PreRelease []string
+	Build      string
+}
+
+// ParseVersion 解析语义化版本，允许前缀 v，缺省的次版本号和修订号视为 0
+func ParseVersion(s string) (Version, error) {
+	var v Version
+
+	text := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if text == "" {
+		return v, fmt.Errorf("无效的版本号: %q", s)
+	}
+
+	if i := strings.IndexByte(text, '+'); i >= 0 {
+		v.Build = text[i+1:]
+		text = text[:i]
+		if err := checkIdentifiers(v.Build, false); err != nil {
+			return v, fmt.Errorf("无效的版本号 %q: %v", s, err)
+		}
+	}
+
+	if i := strings.IndexByte(text, '-'); i >= 0 {
+		pre := text[i+1:]
+		text = text[:i]
+		if err := checkIdentifiers(pre, true); err != nil {
+			return v, fmt.Errorf("无效的版本号 %q: %v", s, err)
+		}
+		v.PreRelease = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(text, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("无效的版本号: %q", s)
+	}
+	numbers := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		if !isNumeric(part) || (len(part) > 1 && part[0] == '0') {
+			return v, fmt.Errorf("无效的版本号: %q", s)
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return v, fmt.Errorf("无效的版本号 %q: %v", s, err)
+		}
+		*numbers[i] = n
+	}
+
+	return v, nil
+}
+
+func checkIdentifiers(s string, preRelease bool) error {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return fmt.Errorf("空标识符")
+		}
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return fmt.Errorf("非法字符 %q", c)
+			}
+		}
+		if preRelease && len(id) > 1 && id[0] == '0' && isNumeric(id) {
+			return fmt.Errorf("数字标识符不能以 0 开头: %s", id)
+		}
+	}
+	return nil
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare 按语义化版本的优先级比较，构建元数据不参与比较
+//
+// v < other 返回 -1，v == other 返回 0，v > other 返回 1
+func (v Version) Compare(other Version) int {
+	if c := compareUint(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	// 正式版本高于预发布版本
+	switch {
+	case len(v.PreRelease) == 0 && len(other.PreRelease) == 0:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(other.PreRelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.PreRelease) && i < len(other.PreRelease); i++ {
+		if c := comparePreRelease(v.PreRelease[i], other.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.PreRelease)), uint64(len(other.PreRelease)))
+}
+
+// comparePreRelease 数字标识符按数值比较，且低于字母数字标识符
+func comparePreRelease(a, b string) int {
+	aNum, bNum := isNumeric(a), isNumeric(b)
+	switch {
+	case aNum && bNum:
+		if c := compareUint(uint64(len(a)), uint64(len(b))); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// CompareVersions 比较两个版本号字符串
+func CompareVersions(a, b string) (int, error) {
+	va, err := ParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := ParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+	}{
+		{"1.2.3", Version{Major: 1, Minor: 2, Patch: 3}},
+		{"v1.2.3", Version{Major: 1, Minor: 2, Patch: 3}},
+		{" 1.2.3 ", Version{Major: 1, Minor: 2, Patch: 3}},
+		{"1", Version{Major: 1}},
+		{"1.2", Version{Major: 1, Minor: 2}},
+		{"0.0.0", Version{}},
+		{"1.0.0-rc.1", Version{Major: 1, PreRelease: []string{"rc", "1"}}},
+		{"1.0.0-alpha-1", Version{Major: 1, PreRelease: []string{"alpha-1"}}},
+		{"1.0.0+build.5", Version{Major: 1, Build: "build.5"}},
+		{"1.0.0-beta+exp.sha.5114f85", Version{Major: 1, PreRelease: []string{"beta"}, Build: "exp.sha.5114f85"}},
+		{"1.0.0+001", Version{Major: 1, Build: "001"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if err != nil {
+			t.Errorf("ParseVersion(%q) error: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVersion(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseVersionInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"v",
+		"1.2.3.4",
+		"01.2.3",
+		"1.02.3",
+		"1.2.x",
+		"1..3",
+		"-1.2.3",
+		"1.2.3-",
+		"1.2.3-rc..1",
+		"1.2.3-01",
+		"1.2.3-rc_1",
+		"1.2.3+",
+		"1.2.3+a..b",
+		"99999999999999999999.0.0",
+	} {
+		if v, err := ParseVersion(in); err == nil {
+			t.Errorf("ParseVersion(%q) = %v, want error", in, v)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.10", "1.0.9", 1},
+		{"1.10.0", "1.9.9", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"1.2", "1.2.0", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		// semver.org 第 11 条中的排序示例
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+	}
+	for _, tt := range tests {
+		got, err := CompareVersions(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("CompareVersions(%q, %q) error: %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if back, _ := CompareVersions(tt.b, tt.a); back != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, back, -tt.want)
+		}
+	}
+
+	if _, err := CompareVersions("1.0.0", "abc"); err == nil {
+		t.Error("CompareVersions(\"1.0.0\", \"abc\") 没有返回错误")
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	for _, in := range []string{"1.2.3", "1.0.0-rc.1", "1.0.0-beta+exp.5", "0.0.1+build"} {
+		v, err := ParseVersion(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v.String(); got != in {
+			t.Errorf("ParseVersion(%q).String() = %q", in, got)
+		}
+	}
+}
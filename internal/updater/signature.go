@@ -0,0 +1,246 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	KeyListFile     = "keys.ini"
+	SignatureSuffix = ".sig"
+	// KeyringFile 本机保存的轮换结果，与版本文件位于同一目录
+	KeyringFile = "trusted_keys.ini"
+)
+
+// TrustedKeys 编译时嵌入的根公钥，多个公钥以逗号分隔（base64 编码）
+//
+//	go build -ldflags "-X autoupdate/internal/updater.TrustedKeys=<base64>,<base64>"
+var TrustedKeys string
+
+// ErrSignature 签名校验失败，更新会以 ExitCodeSignature 退出
+var ErrSignature = errors.New("签名校验失败")
+
+// keyring 受信任的签名公钥集合
+type keyring struct {
+	keys []ed25519.PublicKey
+	// revoked 历次轮换中吊销的公钥，之后的公钥列表不能再加入
+	revoked []ed25519.PublicKey
+	// rotated 本机或本次运行已经通过公钥列表轮换过公钥
+	rotated bool
+}
+
+func parsePublicKeys(list string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(item)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析公钥 %q: %v", item, err)
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("公钥长度错误 %q", item)
+		}
+		keys = append(keys, ed25519.PublicKey(raw))
+	}
+	return keys, nil
+}
+
+func decodeSignature(text string) ([]byte, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("%w: 无法解析签名: %v", ErrSignature, err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: 签名长度错误", ErrSignature)
+	}
+	return sig, nil
+}
+
+// newEmbeddedKeyring 由逗号分隔的根公钥创建公钥列表，通常是编译时嵌入的 TrustedKeys
+func newEmbeddedKeyring(trusted string) (*keyring, error) {
+	keys, err := parsePublicKeys(trusted)
+	if err != nil {
+		return nil, err
+	}
+	return &keyring{keys: keys}, nil
+}
+
+// Empty 没有任何公钥（未嵌入公钥的开发版本），只有调试模式允许跳过签名校验
+func (k *keyring) Empty() bool {
+	return len(k.keys) == 0
+}
+
+func (k *keyring) verify(message []byte, sigText string) error {
+	sig, err := decodeSignature(sigText)
+	if err != nil {
+		return err
+	}
+	for _, key := range k.keys {
+		if ed25519.Verify(key, message, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: 没有匹配的公钥", ErrSignature)
+}
+
+// rotate 使用由当前公钥签名的公钥列表更新公钥集合
+//
+// 公钥列表格式:
+//
+//	keys = <base64>,<base64>
+//	revoked = <base64>
+//
+// 列表中的公钥加入集合，revoked 中的公钥从集合中移除，
+// 但签署该列表的公钥不能被它自己吊销。
+func (k *keyring) rotate(data []byte, sigText string) error {
+	sig, err := decodeSignature(sigText)
+	if err != nil {
+		return err
+	}
+
+	var signer ed25519.PublicKey
+	for _, key := range k.keys {
+		if ed25519.Verify(key, data, sig) {
+			signer = key
+			break
+		}
+	}
+	if signer == nil {
+		return fmt.Errorf("%w: 公钥列表签名无效", ErrSignature)
+	}
+
+	cfg, err := ini.Load(data)
+	if err != nil {
+		return fmt.Errorf("无法解析公钥列表: %v", err)
+	}
+	added, err := parsePublicKeys(cfg.Section("").Key("keys").String())
+	if err != nil {
+		return err
+	}
+	revoked, err := parsePublicKeys(cfg.Section("").Key("revoked").String())
+	if err != nil {
+		return err
+	}
+
+	for _, key := range revoked {
+		if !key.Equal(signer) && !containsKey(k.revoked, key) {
+			k.revoked = append(k.revoked, key)
+		}
+	}
+	k.apply(added)
+	k.rotated = true
+
+	return nil
+}
+
+// apply 加入 added 中未被吊销的公钥，并移除所有已吊销的公钥
+func (k *keyring) apply(added []ed25519.PublicKey) {
+	keys := append([]ed25519.PublicKey{}, k.keys...)
+	for _, key := range added {
+		if !containsKey(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	k.keys = keys[:0]
+	for _, key := range keys {
+		if !containsKey(k.revoked, key) {
+			k.keys = append(k.keys, key)
+		}
+	}
+}
+
+// load 应用本机保存的轮换结果，文件不存在时表示从未轮换过公钥
+//
+// 吊销的公钥即使仍然嵌入在程序中也不再受信任。
+func (k *keyring) load(filePath string) error {
+	content, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	cfg, err := ini.Load(content)
+	if err != nil {
+		return fmt.Errorf("无法解析本机公钥列表: %v", err)
+	}
+	added, err := parsePublicKeys(cfg.Section("").Key("keys").String())
+	if err != nil {
+		return err
+	}
+	revoked, err := parsePublicKeys(cfg.Section("").Key("revoked").String())
+	if err != nil {
+		return err
+	}
+
+	for _, key := range revoked {
+		if !containsKey(k.revoked, key) {
+			k.revoked = append(k.revoked, key)
+		}
+	}
+	k.apply(added)
+	k.rotated = true
+	return nil
+}
+
+// save 保存轮换后的公钥和吊销记录，下次运行时由 load 读取
+func (k *keyring) save(filePath string) error {
+	content := fmt.Sprintf("keys=%s\nrevoked=%s\n", encodePublicKeys(k.keys), encodePublicKeys(k.revoked))
+	if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("保存公钥列表失败: %v", err)
+	}
+	return nil
+}
+
+func encodePublicKeys(keys []ed25519.PublicKey) string {
+	items := make([]string, len(keys))
+	for i, key := range keys {
+		items[i] = base64.StdEncoding.EncodeToString(key)
+	}
+	return strings.Join(items, ",")
+}
+
+func containsKey(keys []ed25519.PublicKey, key ed25519.PublicKey) bool {
+	for _, k := range keys {
+		if k.Equal(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyPackage 校验更新包签名，签名内容为更新包的 SHA-512 摘要
+func (k *keyring) verifyPackage(filePath string, sigText string) error {
+	if sigText == "" {
+		return fmt.Errorf("%w: 版本信息中缺少更新包签名", ErrSignature)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha512.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	if err := k.verify(hash.Sum(nil), sigText); err != nil {
+		return fmt.Errorf("更新包%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package updater
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testKey struct {
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+func newTestKey(t *testing.T) testKey {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{pub, priv}
+}
+
+func (k testKey) sign(data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(k.priv, data))
+}
+
+func (k testKey) encoded() string {
+	return base64.StdEncoding.EncodeToString(k.pub)
+}
+
+// keyList 生成 keys.ini 的内容
+func keyList(keys []testKey, revoked []testKey) []byte {
+	encode := func(list []testKey) string {
+		items := make([]string, len(list))
+		for i, k := range list {
+			items[i] = k.encoded()
+		}
+		return strings.Join(items, ",")
+	}
+	return []byte("keys = " + encode(keys) + "\nrevoked = " + encode(revoked) + "\n")
+}
+
+func testKeyring(t *testing.T, keys ...testKey) *keyring {
+	t.Helper()
+
+	items := make([]string, len(keys))
+	for i, k := range keys {
+		items[i] = k.encoded()
+	}
+	kr, err := newEmbeddedKeyring(strings.Join(items, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestKeyringRotate(t *testing.T) {
+	root, added, other, stranger := newTestKey(t), newTestKey(t), newTestKey(t), newTestKey(t)
+
+	tests := []struct {
+		name    string
+		list    []byte
+		signer  testKey
+		want    error
+		trusted []testKey
+		removed []testKey
+	}{
+		{"有效的公钥列表", keyList([]testKey{added}, nil), root, nil, []testKey{root, added}, nil},
+		{"签名无效", keyList([]testKey{added}, nil), stranger, ErrSignature, []testKey{root}, []testKey{added}},
+		{"吊销其他公钥", keyList([]testKey{added}, []testKey{other}), root, nil, []testKey{root, added}, []testKey{other}},
+		{"签名者不能吊销自己", keyList([]testKey{added}, []testKey{root}), root, nil, []testKey{root, added}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kr := testKeyring(t, root, other)
+			err := kr.rotate(tt.list, tt.signer.sign(tt.list))
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("rotate() = %v, want %v", err, tt.want)
+			}
+			for _, k := range tt.trusted {
+				if !containsKey(kr.keys, k.pub) {
+					t.Errorf("公钥 %s 不受信任", k.encoded())
+				}
+			}
+			for _, k := range tt.removed {
+				if containsKey(kr.keys, k.pub) {
+					t.Errorf("公钥 %s 仍然受信任", k.encoded())
+				}
+			}
+			if kr.rotated != (tt.want == nil) {
+				t.Errorf("rotated = %t", kr.rotated)
+			}
+		})
+	}
+}
+
+func TestKeyringRevocationPersists(t *testing.T) {
+	root, old, added := newTestKey(t), newTestKey(t), newTestKey(t)
+	path := filepath.Join(t.TempDir(), KeyringFile)
+
+	kr := testKeyring(t, root, old)
+	list := keyList([]testKey{added}, []testKey{old})
+	if err := kr.rotate(list, root.sign(list)); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// 下次运行时被吊销的公钥仍然嵌入在程序中
+	kr = testKeyring(t, root, old)
+	if err := kr.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !kr.rotated {
+		t.Error("load() 之后 rotated = false")
+	}
+	message := []byte("version = 1.2.0\n")
+	if err := kr.verify(message, old.sign(message)); !errors.Is(err, ErrSignature) {
+		t.Errorf("被吊销的公钥签名: %v, want %v", err, ErrSignature)
+	}
+	if err := kr.verify(message, added.sign(message)); err != nil {
+		t.Errorf("轮换加入的公钥签名: %v", err)
+	}
+
+	// 之后的公钥列表不能重新加入被吊销的公钥
+	list = keyList([]testKey{old}, nil)
+	if err := kr.rotate(list, root.sign(list)); err != nil {
+		t.Fatal(err)
+	}
+	if containsKey(kr.keys, old.pub) {
+		t.Error("公钥列表重新加入了被吊销的公钥")
+	}
+}
+
+func TestVerifyPackage(t *testing.T) {
+	key := newTestKey(t)
+	kr := testKeyring(t, key)
+
+	data := []byte("package")
+	path := filepath.Join(t.TempDir(), "update.zip")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum512(data)
+	other := sha512.Sum512([]byte("other package"))
+
+	tests := []struct {
+		name string
+		sig  string
+		want error
+	}{
+		{"有效的签名", key.sign(sum[:]), nil},
+		{"其他更新包的签名", key.sign(other[:]), ErrSignature},
+		{"签名内容不是 SHA-512", key.sign(data), ErrSignature},
+		{"缺少签名", "", ErrSignature},
+		{"签名格式错误", "not base64", ErrSignature},
+	}
+	for _, tt := range tests {
+		err := kr.verifyPackage(path, tt.sig)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: verifyPackage() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// signedServer 提供 files 中的文件，不存在的文件返回 404；set 在测试中替换文件
+func signedServer(t *testing.T, files map[string][]byte) (*httptest.Server, func(name string, data []byte)) {
+	t.Helper()
+
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		data, ok := files[strings.TrimPrefix(r.URL.Path, "/")]
+		mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func(name string, data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		if data == nil {
+			delete(files, name)
+		} else {
+			files[name] = data
+		}
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	root, added, other := newTestKey(t), newTestKey(t), newTestKey(t)
+	manifest := []byte("version = 1.2.0\nfilename = update.zip\nsha256 = " + testSHA256 + "\nfullpackage = https://example.com/full.exe\n")
+	list := keyList([]testKey{added}, nil)
+
+	tests := []struct {
+		name string
+		// rotated 本机已经保存过轮换结果
+		rotated bool
+		// noKeys 没有嵌入公钥
+		noKeys bool
+		debug  bool
+		files  map[string][]byte
+		want   error
+	}{
+		{"没有公钥列表", false, false, false, map[string][]byte{
+			"ver.ini": manifest, "ver.ini.sig": []byte(root.sign(manifest)),
+		}, nil},
+		{"轮换后由新公钥签名", false, false, false, map[string][]byte{
+			"ver.ini": manifest, "ver.ini.sig": []byte(added.sign(manifest)),
+			"keys.ini": list, "keys.ini.sig": []byte(root.sign(list)),
+		}, nil},
+		{"公钥列表缺少签名", false, false, false, map[string][]byte{
+			"ver.ini": manifest, "ver.ini.sig": []byte(root.sign(manifest)),
+			"keys.ini": list,
+		}, ErrSignature},
+		{"公钥列表被篡改", false, false, false, map[string][]byte{
+			"ver.ini": manifest, "ver.ini.sig": []byte(root.sign(manifest)),
+			"keys.ini": list, "keys.ini.sig": []byte(other.sign(list)),
+		}, ErrSignature},
+		{"轮换后公钥列表消失", true, false, false, map[string][]byte{
+			"ver.ini": manifest, "ver.ini.sig": []byte(root.sign(manifest)),
+		}, ErrSignature},
+		{"轮换后公钥列表被篡改", true, false, false, map[string][]byte{
+			"ver.ini": manifest, "ver.ini.sig": []byte(root.sign(manifest)),
+			"keys.ini": keyList([]testKey{other}, nil), "keys.ini.sig": []byte(root.sign(list)),
+		}, ErrSignature},
+		{"版本信息缺少签名", false, false, false, map[string][]byte{
+			"ver.ini": manifest,
+		}, ErrSignature},
+		{"版本信息签名不受信任", false, false, false, map[string][]byte{
+			"ver.ini": manifest, "ver.ini.sig": []byte(other.sign(manifest)),
+		}, ErrSignature},
+		{"没有嵌入公钥", false, true, false, map[string][]byte{
+			"ver.ini": manifest,
+		}, ErrSignature},
+		{"调试模式下没有嵌入公钥", false, true, true, map[string][]byte{
+			"ver.ini": manifest,
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := signedServer(t, tt.files)
+			u := newDownloadTestUpdater(t, srv.URL)
+			u.TrustedKeys = root.encoded()
+			if tt.noKeys {
+				u.TrustedKeys = ""
+			}
+			u.debugMode = tt.debug
+			if tt.rotated {
+				if err := testKeyring(t, root, added).save(u.keyringPath()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := u.verifyManifest(context.Background(), u.Config.MirrorList()[0], manifest)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("verifyManifest() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyManifestSavesRotation(t *testing.T) {
+	root, old, added := newTestKey(t), newTestKey(t), newTestKey(t)
+	manifest := []byte("version = 1.2.0\nfilename = update.zip\nsha256 = " + testSHA256 + "\nfullpackage = https://example.com/full.exe\n")
+	list := keyList([]testKey{added}, []testKey{old})
+
+	srv, set := signedServer(t, map[string][]byte{
+		"ver.ini": manifest, "ver.ini.sig": []byte(added.sign(manifest)),
+		"keys.ini": list, "keys.ini.sig": []byte(root.sign(list)),
+	})
+	u := newDownloadTestUpdater(t, srv.URL)
+	u.TrustedKeys = root.encoded() + "," + old.encoded()
+	mirror := u.Config.MirrorList()[0]
+
+	if _, err := u.verifyManifest(context.Background(), mirror, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务器上的公钥列表被删除后，用被吊销的公钥签名的版本信息不能通过校验
+	set("keys.ini", nil)
+	set("keys.ini.sig", nil)
+	set("ver.ini.sig", []byte(old.sign(manifest)))
+	if _, err := u.verifyManifest(context.Background(), mirror, manifest); !errors.Is(err, ErrSignature) {
+		t.Fatalf("verifyManifest() = %v, want %v", err, ErrSignature)
+	}
+}
+
+func TestFindUpdateSignatureExitCode(t *testing.T) {
+	root, other := newTestKey(t), newTestKey(t)
+	manifest := []byte("version = 1.2.0\nfilename = update.zip\nsha256 = " + testSHA256 + "\nfullpackage = https://example.com/full.exe\n")
+
+	srv, _ := signedServer(t, map[string][]byte{
+		"ver.ini": manifest, "ver.ini.sig": []byte(other.sign(manifest)),
+	})
+	u := newDownloadTestUpdater(t, srv.URL)
+	u.TrustedKeys = root.encoded()
+
+	if _, code, ok := u.findUpdate(context.Background()); ok || code != ExitCodeSignature {
+		t.Fatalf("findUpdate() = %d, %t, want %d", code, ok, ExitCodeSignature)
+	}
+	if !errors.Is(u.lastErr, ErrSignature) {
+		t.Fatalf("lastErr = %v, want %v", u.lastErr, ErrSignature)
+	}
+}
@@ -0,0 +1,300 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// StagedFile 已下载、等待安装的更新，位于 TempDir 下
+	StagedFile = "staged.ini"
+
+	// stagedManifestFile 下载时的版本信息，签名保存在加上 SignatureSuffix 的文件中，位于 TempDir 下
+	stagedManifestFile = "staged-manifest"
+
+	// stagedFilesFile 等待安装的文件清单，保存下载时的原始内容，位于 TempDir 下
+	stagedFilesFile = "staged-files"
+)
+
+// ErrNotStaged 没有已下载、等待安装的更新
+var ErrNotStaged = errors.New("没有已下载的更新")
+
+// stagedUpdate 已下载并校验、等待安装的更新
+type stagedUpdate struct {
+	Version VersionInfo
+	// Package 更新包路径，按文件更新时为空
+	Package string
+	// Files 按文件更新时新版本的文件清单
+	Files []FileEntry
+	// Variant 下载时选择的更新包变体，安装时按它重新选出更新包
+	Variant string
+	// fileList Files 的原始内容，安装前用签名的版本信息中的摘要重新校验
+	fileList []byte
+
+	// compared 为 true 时 changes 和 removed 是下载时的比较结果，
+	// 从 staged.ini 读取时为 false，安装前重新比较
+	compared bool
+	changes  []fileChange
+	removed  []string
+}
+
+func (u *Updater) stagedPath() string {
+	return u.tempPath(StagedFile)
+}
+
+// stage 下载新版本并校验，不修改安装目录中的文件
+//
+// 有文件清单时只下载变化的文件，失败后下载完整更新包。
+func (u *Updater) stage(ctx context.Context) (*stagedUpdate, error) {
+	// 在当前目录下创建 tmp 目录
+	if err := os.MkdirAll(u.tempPath(), 0755); err != nil {
+		return nil, fmt.Errorf("创建临时目录失败: %v", err)
+	}
+
+	if u.NewVer.Files.Filename != "" {
+		staged, err := u.stageFiles(ctx)
+		if err == nil {
+			return staged, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("下载更新文件失败: %v", err)
+		}
+		u.log.Warn(fmt.Sprintf("按文件更新失败，使用完整更新包: %v", err))
+	}
+
+	return u.stagePackage(ctx)
+}
+
+// applyStaged 等待宿主程序退出后替换文件
+func (u *Updater) applyStaged(ctx context.Context, staged *stagedUpdate) (*installTransaction, error) {
+	u.phase(phaseInstall)
+	if staged.Package == "" {
+		return u.installFiles(ctx, staged)
+	}
+
+	if err := u.waitForApp(ctx); err != nil {
+		return nil, err
+	}
+
+	tx, err := u.extractAndReplace(staged.Package)
+	if err != nil {
+		u.emit(Event{Type: EventInstallFailed, Version: u.NewVer.Version, Error: err.Error()})
+		return nil, fmt.Errorf("更新失败: %w", err)
+	}
+	return tx, nil
+}
+
+// finishInstall 更新本地版本文件，保留上一版本并提交安装
+func (u *Updater) finishInstall(staged *stagedUpdate, tx *installTransaction) error {
+	// 健康检查失败时需要恢复旧的版本文件和文件清单
+	previous, err := u.stagePrevious()
+	if err != nil {
+		u.emit(Event{Type: EventInstallFailed, Version: u.NewVer.Version, Error: err.Error()})
+		if rbErr := tx.rollback(); rbErr != nil {
+			return fmt.Errorf("保存当前版本信息失败: %v; 回滚失败: %v", err, rbErr)
+		}
+		return fmt.Errorf("保存当前版本信息失败: %v", err)
+	}
+
+	// 文件全部替换成功后才更新本地版本文件
+	err = writeVersionFile(u.versionFilePath, u.NewVer)
+
+	if err != nil {
+		os.RemoveAll(previous)
+		u.emit(Event{Type: EventInstallFailed, Version: u.NewVer.Version, Error: err.Error()})
+		if rbErr := tx.rollback(); rbErr != nil {
+			return fmt.Errorf("更新版本文件失败: %v; 回滚失败: %v", err, rbErr)
+		}
+		return fmt.Errorf("更新版本文件失败: %v", err)
+	}
+
+	// 保留被替换的文件，失败只影响之后能否回滚
+	if err := u.keepPrevious(previous, tx); err != nil {
+		u.log.Warn(fmt.Sprintf("保留上一版本失败: %v", err))
+		os.RemoveAll(previous)
+	}
+	tx.commit()
+	u.clearStaged()
+
+	// 记录已安装的文件，下次按文件更新时据此删除不再需要的文件
+	if staged.Package == "" {
+		writeFileList(u.installedFilesPath(), staged.Files)
+	} else {
+		os.Remove(u.installedFilesPath())
+	}
+
+	// 保留更新包作为下次增量更新的基础，失败只影响下次能否使用补丁
+	if staged.Package == "" {
+		os.RemoveAll(u.tempPath(InstalledDir))
+	} else if err := u.keepPackage(staged.Package, u.NewVer.Filename); err != nil {
+		os.Remove(staged.Package)
+	}
+	u.SetProgress(1.0)
+	u.emit(Event{Type: EventInstalled, CurrentVersion: u.CurrentVer.Version, Version: u.NewVer.Version})
+
+	return nil
+}
+
+// saveStaged 记录已下载的更新，之后由 install 命令安装
+//
+//	version = 1.2.0
+//	channel = stable
+//	variant = musl
+//	package = update_1.2.0.zip
+//
+// 同时保存签名的版本信息和文件清单的原始内容，更新包的摘要和签名以版本信息为准，
+// staged.ini 被修改后也无法安装未经签名的更新。
+func (u *Updater) saveStaged(staged *stagedUpdate) error {
+	vi := staged.Version
+
+	if err := ioutil.WriteFile(u.tempPath(stagedManifestFile), vi.RawData, 0644); err != nil {
+		return err
+	}
+	sigPath := u.tempPath(stagedManifestFile + SignatureSuffix)
+	if vi.RawSignature == nil {
+		os.Remove(sigPath)
+	} else if err := ioutil.WriteFile(sigPath, vi.RawSignature, 0644); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "version = %s\n", vi.Version)
+	fmt.Fprintf(&b, "channel = %s\n", vi.Channel)
+	fmt.Fprintf(&b, "variant = %s\n", staged.Variant)
+	if staged.Package != "" {
+		fmt.Fprintf(&b, "package = %s\n", filepath.Base(staged.Package))
+	} else if err := ioutil.WriteFile(u.tempPath(stagedFilesFile), staged.fileList, 0644); err != nil {
+		return err
+	}
+
+	// 先写入临时文件再重命名，避免留下不完整的记录
+	tmpPath := u.stagedPath() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, []byte(b.String()), 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, u.stagedPath())
+}
+
+// loadStaged 读取已下载的更新，重新校验版本信息的签名，并确认更新包或文件仍然完整
+func (u *Updater) loadStaged() (*stagedUpdate, error) {
+	content, err := ioutil.ReadFile(u.stagedPath())
+	if os.IsNotExist(err) {
+		return nil, ErrNotStaged
+	} else if err != nil {
+		return nil, err
+	}
+	cfg, err := ini.Load(content)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析已下载的更新: %v", err)
+	}
+	section := cfg.Section("")
+
+	keys, err := u.loadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	variant := section.Key("variant").String()
+	vi, err := u.loadStagedVersion(keys, section.Key("version").String(), section.Key("channel").String(), variant)
+	if err != nil {
+		return nil, err
+	}
+	staged := &stagedUpdate{Version: vi, Variant: variant}
+
+	if name := section.Key("package").String(); name != "" {
+		staged.Package = u.tempPath(filepath.Base(name))
+
+		digest, err := strongestDigest(vi.Digests, u.AllowMD5)
+		if err != nil {
+			return nil, err
+		}
+		h := digest.newHash()
+		if err := hashFile(staged.Package, h); err != nil {
+			return nil, fmt.Errorf("已下载的更新包不可用: %v", err)
+		}
+		if err := digest.Verify(h); err != nil {
+			return nil, fmt.Errorf("已下载的更新包校验失败: %v", err)
+		}
+		if !keys.Empty() {
+			if err := keys.verifyPackage(staged.Package, vi.Signature); err != nil {
+				return nil, fmt.Errorf("已下载的%w", err)
+			}
+		}
+		return staged, nil
+	}
+
+	list, err := ioutil.ReadFile(u.tempPath(stagedFilesFile))
+	if err != nil {
+		return nil, fmt.Errorf("已下载的更新缺少文件清单")
+	}
+	digest, err := strongestDigest(vi.Files.Digests, u.AllowMD5)
+	if err != nil {
+		return nil, err
+	}
+	h := digest.newHash()
+	h.Write(list)
+	if err := digest.Verify(h); err != nil {
+		return nil, fmt.Errorf("已下载的文件清单校验失败: %v", err)
+	}
+	staged.Files, err = decodeFileList(list, "", vi.Files.Filename)
+	if err != nil {
+		return nil, err
+	}
+	staged.fileList = list
+	return staged, nil
+}
+
+// loadStagedVersion 用 keys 校验下载时保存的版本信息，从中取出已下载的版本
+//
+// 按下载时的变体选出更新包，与当前配置无关。keys 为空（调试模式）时不校验签名。
+func (u *Updater) loadStagedVersion(keys *keyring, version string, channel string, variant string) (VersionInfo, error) {
+	var vi VersionInfo
+	data, err := ioutil.ReadFile(u.tempPath(stagedManifestFile))
+	if err != nil {
+		return vi, fmt.Errorf("已下载的更新缺少版本信息，请重新下载更新")
+	}
+
+	var sig []byte
+	if !keys.Empty() {
+		sig, err = ioutil.ReadFile(u.tempPath(stagedManifestFile + SignatureSuffix))
+		if err != nil {
+			return vi, fmt.Errorf("%w: 已下载的更新缺少版本信息签名", ErrSignature)
+		}
+		if err := keys.verify(data, string(sig)); err != nil {
+			return vi, fmt.Errorf("已下载的版本信息%w", err)
+		}
+	}
+
+	releases, err := decodeManifest(data, "", "")
+	if err != nil {
+		return vi, err
+	}
+	for _, release := range releases {
+		if release.Version != version || release.Channel != channel {
+			continue
+		}
+		vi, err = resolveArtifact(release, runtime.GOOS, runtime.GOARCH, variant)
+		if err != nil {
+			return vi, err
+		}
+		vi.RawSignature = sig
+		return vi, nil
+	}
+	return vi, fmt.Errorf("已下载的版本信息中没有版本 %s", version)
+}
+
+// clearStaged 删除已下载更新的记录，下载的文件由安装或 clean 清理
+func (u *Updater) clearStaged() {
+	os.Remove(u.stagedPath())
+	os.Remove(u.tempPath(stagedManifestFile))
+	os.Remove(u.tempPath(stagedManifestFile + SignatureSuffix))
+	os.Remove(u.tempPath(stagedFilesFile))
+}
@@ -0,0 +1,182 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func stagedTestManifest(version string, sha256 string, signature string) string {
+	return fmt.Sprintf("version = %s\nfilename = update_%s.zip\nsha256 = %s\nsignature = %s\nfullpackage = https://example.com/full.exe\n",
+		version, version, sha256, signature)
+}
+
+// stageTestPackage 保存一个签名的已下载更新，返回签名用的私钥
+func stageTestPackage(t *testing.T) (*Updater, ed25519.PrivateKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	u := newUpdater(dir, dir, NewEventUI(nil))
+	u.TrustedKeys = base64.StdEncoding.EncodeToString(pub)
+	if err := os.MkdirAll(u.tempPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("package")
+	packagePath := u.tempPath("update_1.2.0.zip")
+	if err := os.WriteFile(packagePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum512(data)
+	manifest := stagedTestManifest("1.2.0", testDigest(data).Value, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, sum[:])))
+
+	releases, err := decodeManifest([]byte(manifest), "", "ver.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vi := releases[0]
+	vi.RawSignature = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, vi.RawData)))
+	if err := u.saveStaged(&stagedUpdate{Version: vi, Package: packagePath}); err != nil {
+		t.Fatal(err)
+	}
+	return u, priv
+}
+
+func TestLoadStaged(t *testing.T) {
+	u, _ := stageTestPackage(t)
+
+	staged, err := u.loadStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staged.Version.Version != "1.2.0" || staged.Package != u.tempPath("update_1.2.0.zip") {
+		t.Fatalf("loadStaged() = %s, %s", staged.Version.Version, staged.Package)
+	}
+}
+
+func TestLoadStagedVariant(t *testing.T) {
+	u, priv := stageTestPackage(t)
+
+	// 版本同时提供默认构建和 musl 变体，默认构建的摘要与已下载的更新包不同
+	data := []byte("musl package")
+	musl := u.tempPath("update_1.2.0_musl.zip")
+	if err := os.WriteFile(musl, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum512(data)
+	manifest := fmt.Sprintf("[1.2.0]\nfilename = update_1.2.0.zip\nsha256 = %s\nfullpackage = https://example.com/full.exe\n\n"+
+		"[1.2.0 %s/%s]\nfilename = update_1.2.0_default.zip\nsha256 = %s\n\n"+
+		"[1.2.0 %s/%s/musl]\nfilename = update_1.2.0_musl.zip\nsha256 = %s\nsignature = %s\n",
+		testSHA256, runtime.GOOS, runtime.GOARCH, testSHA256,
+		runtime.GOOS, runtime.GOARCH, testDigest(data).Value, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, sum[:])))
+
+	u.Config.Variant = "musl"
+	releases, err := decodeManifest([]byte(manifest), "", "ver.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vi, err := resolveArtifact(releases[0], runtime.GOOS, runtime.GOARCH, u.Config.Variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vi.RawSignature = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, vi.RawData)))
+	if err := u.saveStaged(&stagedUpdate{Version: vi, Package: musl, Variant: u.Config.Variant}); err != nil {
+		t.Fatal(err)
+	}
+
+	// install 命令不读取 -variant，安装时使用下载时的变体
+	u.Config.Variant = ""
+	staged, err := u.loadStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staged.Version.Filename != "update_1.2.0_musl.zip" || staged.Variant != "musl" {
+		t.Fatalf("loadStaged() = %s, variant %q", staged.Version.Filename, staged.Variant)
+	}
+}
+
+func TestLoadStagedRejectsTampering(t *testing.T) {
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, u *Updater, priv ed25519.PrivateKey)
+		want   error
+	}{
+		{"修改版本信息", func(t *testing.T, u *Updater, priv ed25519.PrivateKey) {
+			appendFile(t, u.tempPath(stagedManifestFile), "mandatory = true\n")
+		}, ErrSignature},
+		{"删除版本信息签名", func(t *testing.T, u *Updater, priv ed25519.PrivateKey) {
+			os.Remove(u.tempPath(stagedManifestFile + SignatureSuffix))
+		}, ErrSignature},
+		{"公钥不受信任", func(t *testing.T, u *Updater, priv ed25519.PrivateKey) {
+			u.TrustedKeys = base64.StdEncoding.EncodeToString(otherKey)
+		}, ErrSignature},
+		{"替换更新包和摘要", func(t *testing.T, u *Updater, priv ed25519.PrivateKey) {
+			// 重新签名的版本信息不能让未签名的更新包通过校验
+			data := []byte("evil")
+			if err := os.WriteFile(u.tempPath("update_1.2.0.zip"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			sig := base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))
+			writeSigned(t, u, priv, stagedTestManifest("1.2.0", testDigest(data).Value, sig))
+		}, ErrSignature},
+		{"版本信息中没有该版本", func(t *testing.T, u *Updater, priv ed25519.PrivateKey) {
+			writeSigned(t, u, priv, stagedTestManifest("1.3.0", testSHA256, ""))
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, priv := stageTestPackage(t)
+			tt.tamper(t, u, priv)
+
+			_, err := u.loadStaged()
+			if err == nil {
+				t.Fatal("loadStaged() 没有返回错误")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("loadStaged() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func appendFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeSigned 用 priv 签名 manifest 并替换已下载更新中保存的版本信息
+func writeSigned(t *testing.T, u *Updater, priv ed25519.PrivateKey, manifest string) {
+	t.Helper()
+
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(manifest)))
+	if err := os.WriteFile(u.tempPath(stagedManifestFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(u.tempPath(stagedManifestFile+SignatureSuffix), []byte(sig), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,162 @@
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// PinPrefix SPKI 固定值的前缀，与 HPKP 的 pin-sha256 相同: base64(sha256(SubjectPublicKeyInfo))
+const PinPrefix = "sha256/"
+
+// ErrPinMismatch 服务器证书链中没有与固定值匹配的公钥
+var ErrPinMismatch = errors.New("证书公钥与固定值不匹配")
+
+// TLSConfig 证书校验配置
+//
+//	[tls]
+//	ca_bundle = certs/internal-ca.pem
+//
+//	[pins]
+//	update.example.com = sha256/AAAA..., sha256/BBBB...
+type TLSConfig struct {
+	// CABundle PEM 格式的附加根证书，与系统根证书一起使用
+	CABundle string
+	// Pins 按主机名列出的 SPKI 固定值，已校验证书链中任一证书匹配即可
+	Pins map[string][]string
+}
+
+// Validate 检查固定值格式
+func (c TLSConfig) Validate() error {
+	for host, pins := range c.Pins {
+		for _, pin := range pins {
+			raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, PinPrefix))
+			if err != nil || len(raw) != sha256.Size {
+				return fmt.Errorf("主机 %s 的证书固定值格式错误: %q", host, pin)
+			}
+		}
+	}
+	return nil
+}
+
+// newTLSConfig 构造 tls.Config，insecure 只在调试模式下由调用方传入
+func newTLSConfig(c TLSConfig, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if c.CABundle != "" {
+		pem, err := ioutil.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取 CA 证书: %v", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 证书文件中没有有效的证书: %s", c.CABundle)
+		}
+		config.RootCAs = roots
+	}
+
+	return config, nil
+}
+
+// tlsDialer 建立 TLS 连接并检查证书固定值，dial 为 nil 时直接连接 addr；
+// 没有固定值也没有 dial 时返回 nil，使用 http.Transport 默认的 TLS 连接
+//
+// IP 地址不会作为 SNI 发送，ConnectionState 中拿不到主机名，因此在拨号时按地址匹配固定值。
+// 调试模式通过 dial 把连接转到本地服务器，但仍然按原来的主机名进行 TLS 握手。
+func tlsDialer(config *tls.Config, hostPins map[string][]string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(hostPins) == 0 && dial == nil {
+		return nil
+	}
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+
+	pins := make(map[string]map[string]bool, len(hostPins))
+	for host, list := range hostPins {
+		set := make(map[string]bool, len(list))
+		for _, pin := range list {
+			set[strings.TrimPrefix(pin, PinPrefix)] = true
+		}
+		pins[strings.ToLower(host)] = set
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		c := config.Clone()
+		if c.ServerName == "" {
+			c.ServerName = host
+		}
+		if set, ok := pins[strings.ToLower(host)]; ok {
+			// 在常规校验之后执行，只匹配已校验证书链中的证书：
+			// PeerCertificates 中未参与校验的证书由服务器任意提供，不能作为依据。
+			// 跳过校验时没有已校验的证书链，固定了公钥的主机总是连接失败。
+			c.VerifyConnection = func(cs tls.ConnectionState) error {
+				for _, chain := range cs.VerifiedChains {
+					for _, cert := range chain {
+						if set[spkiPin(cert)] {
+							return nil
+						}
+					}
+				}
+				return fmt.Errorf("%s: %w", host, ErrPinMismatch)
+			}
+		}
+
+		raw, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn := tls.Client(raw, c)
+		if err := conn.HandshakeContext(ctx); err != nil {
+			raw.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// spkiPin 证书公钥的固定值（不含前缀）
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// tlsFailureReason 判断错误是否由证书校验引起，并给出原因
+func tlsFailureReason(err error) (string, bool) {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+
+	switch {
+	case errors.Is(err, ErrPinMismatch):
+		return ErrPinMismatch.Error(), true
+	case errors.As(err, &unknownAuthority):
+		return "证书由未知的颁发机构签发", true
+	case errors.As(err, &hostname):
+		return fmt.Sprintf("证书与主机名 %s 不匹配", hostname.Host), true
+	case errors.As(err, &invalid):
+		if invalid.Reason == x509.Expired {
+			return "证书已过期或尚未生效", true
+		}
+		return fmt.Sprintf("证书无效: %v", invalid), true
+	case errors.As(err, &recordHeader):
+		return "服务器没有使用 TLS", true
+	}
+	return "", false
+}
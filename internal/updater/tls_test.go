@@ -0,0 +1,48 @@
+package updater
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestTLSDialerRedirect 调试模式把连接转到本地服务器时仍然使用 TLS，只有 insecure 时才跳过校验
+func TestTLSDialerRedirect(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+	}
+
+	for _, insecure := range []bool{false, true} {
+		config, err := newTLSConfig(TLSConfig{}, insecure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: config,
+			DialTLSContext:  tlsDialer(config, nil, dial),
+		}}
+		resp, err := client.Get("https://update.example.com/ver.ini")
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		if insecure && err != nil {
+			t.Errorf("insecure: %v", err)
+		}
+		if !insecure {
+			if _, ok := tlsFailureReason(err); !ok {
+				t.Errorf("没有跳过校验时请求自签名服务器: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package updater
+
+import "context"
+
+// UI 更新界面后端
+//
+// 每个平台在对应的 dialog_*.go 中通过 newPlatformUI 提供默认实现，
+// Updater 只通过该接口与界面交互。
+type UI interface {
+	ShowMainWindow()
+	AppendLogText(text string)
+	SetUpdateProgress(progress float64)
+	SetUpdateComplete()
+	ShowUpdateErrorDialog(message string)
+	ShowUpdateConfirmDialog(message string) bool
+	CloseWindow()
+	IsUpdateCancelled() bool
+
+	// AppLoop 在主线程上运行界面事件循环，直到界面关闭
+	AppLoop()
+	// UpdateFinished 通知界面更新流程已结束
+	UpdateFinished()
+}
+
+// ContextConfirmer 可选的界面接口，确认对话框在 ctx 取消时立即返回 false
+type ContextConfirmer interface {
+	ShowUpdateConfirmDialogContext(ctx context.Context, message string) bool
+}
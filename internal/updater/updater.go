@@ -1,20 +1,20 @@
 package updater
 
 import (
-	"archive/zip"
 	"context"
-	"crypto/md5"
-	"crypto/tls"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -31,16 +31,21 @@ const (
 	ExitCodeNoUpdate   = 0
 	ExitCodeNewVersion = 1
 	ExitCodeCancel     = 2
-	ExitCodeError      = -1
-	RetryLimit         = 4
-	ChunkSize          = 1024 * 1024 // 1MB
+	ExitCodeSignature  = 3
+	ExitCodeRelaunch   = 4
+	ExitCodeRolledBack = 5
+	// ExitCodeUpdateAvailable 只检查时发现可以安装的新版本
+	ExitCodeUpdateAvailable = 6
+	// ExitCodeStaged 新版本已下载，等待安装
+	ExitCodeStaged = 7
+	ExitCodeError  = -1
+	RetryLimit     = 4
+	ChunkSize      = 1024 * 1024 // 1MB
 
 )
 
-var (
-	AppName      string
-	IsSilentMode = false
-)
+// debugServerAddr 调试模式下 debug_server.py 的地址
+const debugServerAddr = "127.0.0.1:9808"
 
 type Updater struct {
 	CurrentVer     VersionInfo
@@ -48,59 +53,162 @@ type Updater struct {
 	ExecutableName string
 	debugMode      bool
 
+	// AppName 应用名称，平台界面用作窗口标题
+	AppName string
+	// Silent 不询问是否更新，当前版本过低时直接失败，不打开浏览器
+	Silent bool
+	// TrustedKeys 校验签名的根公钥，默认为编译时嵌入的 TrustedKeys
+	TrustedKeys string
+
+	// dir 安装目录，更新包中的文件替换到这里，TempDir 也位于其中
+	dir string
+
+	// AllowMD5 允许只提供 MD5 摘要的版本信息
+	AllowMD5 bool
+	// Channel 发布通道 (stable/beta/nightly)
+	Channel string
+	// Config 更新源配置
+	Config    Config
+	configErr error
+	// InsecureSkipVerify 跳过证书校验，只在调试模式下生效
+	InsecureSkipVerify bool
+	// Relaunch 更新成功后重新启动的程序，为 nil 时不启动
+	Relaunch *Relaunch
+
+	client *http.Client
+
+	versionFilePath string
+
 	// progressChan chan float64
-	doneChan chan bool
+	doneChan chan error
 	success  bool
+	// lastErr 导致本次更新失败的错误，随 done 事件输出
+	lastErr error
+
+	// log 本次运行的日志，同时输出到界面和日志文件，runLog 为不带阶段的日志
+	log      *slog.Logger
+	runLog   *slog.Logger
+	runStart time.Time
+	logFile  *rotatingFile
+	// logHandlers 嵌入时额外接收日志的 handler
+	logHandlers []slog.Handler
+
+	keys *keyring
+	// checkOnly 只检查更新，不保存公钥轮换结果
+	checkOnly bool
+
+	// mirrorOrder 本次运行的镜像顺序，health 为镜像失败记录
+	mirrorOrder []Mirror
+	health      *mirrorHealth
 
 	Progress uint64
+
+	UI UI
 }
 
 type VersionInfo struct {
 	Version        string
+	Channel        string
 	Filename       string
-	MD5            string
+	Digests        map[string]string
+	Size           int64
 	FullPackageURL string
-	RawData        []byte
+	Signature      string
+	// Notes 更新说明
+	Notes string
+	// MinVersion 可以直接更新到该版本的最低版本，更低的版本需要下载完整安装包
+	MinVersion string
+	// Rollback 服务器明确要求回退到较低的版本
+	Rollback bool
+	// Mandatory 必须安装的版本，宿主程序可以据此阻止用户跳过
+	Mandatory bool
+	// Artifacts 各平台的更新包，为空时使用 Filename 指定的通用更新包
+	Artifacts []Artifact
+	// Patches 通用更新包的补丁，选中平台更新包后替换为该平台的补丁
+	Patches []Patch
+	// Files 通用更新包的文件清单，选中平台更新包后替换为该平台的清单
+	Files   FileList
+	RawData []byte
+	// RawSignature RawData 的签名，随已下载的更新保存，安装前重新校验
+	RawSignature []byte
 }
 
 func NewUpdater(appName string, debug bool, silent bool) *Updater {
+	return NewUpdaterWithUI(appName, debug, silent, newPlatformUI(appName))
+}
+
+// NewUpdaterWithUI 与 NewUpdater 相同，使用指定的界面代替平台默认界面
+//
+// 版本文件和配置位于可执行文件所在的目录，更新包中的文件替换到当前目录。
+func NewUpdaterWithUI(appName string, debug bool, silent bool, ui UI) *Updater {
+
+	exepath, _ := os.Executable()
+
+	// 无法确定可执行文件的目录时使用相对路径
+	execDir, err := filepath.Abs(filepath.Dir(exepath))
+	if err != nil {
+		execDir = ""
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "."
+	}
 
-	IsSilentMode = silent
-	AppName = appName
+	u := newUpdater(dir, execDir, ui)
+	u.AppName = appName
+	u.debugMode = debug
+	u.Silent = silent
+
+	u.UI.ShowMainWindow()
+
+	return u
+}
 
+// newUpdater 读取 versionDir 中的版本文件、配置和通道，安装到 dir
+func newUpdater(dir string, versionDir string, ui UI) *Updater {
 	exepath, _ := os.Executable()
-	execName := filepath.Base(exepath)
 
 	u := &Updater{
 		CurrentVer:     VersionInfo{},
-		ExecutableName: execName,
-		debugMode:      debug,
-		doneChan:       make(chan bool),
+		ExecutableName: filepath.Base(exepath),
+		TrustedKeys:    TrustedKeys,
+		dir:            dir,
+		doneChan:       make(chan error),
 		success:        false,
 		Progress:       0,
+		UI:             ui,
 	}
 
-	var VersionFilePath string
+	u.versionFilePath = filepath.Join(versionDir, VersionFile)
+
+	u.Config, u.configErr = LoadConfig(filepath.Dir(u.versionFilePath))
+	u.health = loadMirrorHealth(filepath.Join(filepath.Dir(u.versionFilePath), MirrorStateFile))
+
 	var err error
-	var execDir string
-	execDir, err = filepath.Abs(filepath.Dir(exepath))
+	u.CurrentVer, err = ReadVersionFile(u.versionFilePath)
 	if err != nil {
-		VersionFilePath = VersionFile
-	} else {
-		VersionFilePath = filepath.Join(execDir, VersionFile)
+		u.CurrentVer.Version = "0.0.0"
 	}
 
-	u.CurrentVer, err = ReadVersionFile(VersionFilePath)
+	u.Channel, err = readChannelFile(u.channelFilePath())
 	if err != nil {
-		u.CurrentVer.Version = "0.0.0"
+		u.Channel = ChannelStable
 	}
 
-	ShowMainWindow()
+	// 开始运行之前的日志只显示在界面上
+	u.runLog = slog.New(&uiHandler{ui: ui, level: &u.Config.Log.Level})
+	u.log = u.runLog
 
 	return u
 }
 
-func (u *Updater) syncUI() {
+// tempPath 返回安装目录下 TempDir 中的路径
+func (u *Updater) tempPath(elem ...string) string {
+	return filepath.Join(append([]string{u.dir, TempDir}, elem...)...)
+}
+
+func (u *Updater) syncUI() (stop func()) {
+	done := make(chan struct{})
 
 	go func() {
 		ticker := time.NewTicker(100 * time.Millisecond)
@@ -110,161 +218,468 @@ func (u *Updater) syncUI() {
 			select {
 			case <-ticker.C:
 				progress := u.GetProgress()
-				SetUpdateProgress(progress)
-			case <-u.doneChan:
+				u.UI.SetUpdateProgress(progress)
+			case <-done:
 				return
 			}
 		}
 	}()
+
+	return func() {
+		close(done)
+		u.UI.SetUpdateProgress(u.GetProgress())
+	}
 }
 
-func (u *Updater) bgTask() {
-	err := u.downloadAndUpdate()
-	u.doneChan <- (err == nil)
-	if err != nil {
-		ShowUpdateErrorDialog(err.Error())
+// watchCancel 把界面上的取消操作转换为取消 ctx，正在进行的请求会立即中断
+func (u *Updater) watchCancel(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if u.UI.IsUpdateCancelled() {
+				cancel()
+				return
+			}
+		}
 	}
+}
 
+func (u *Updater) bgTask(ctx context.Context) {
+	err := u.downloadAndUpdate(ctx)
+	if err != nil {
+		u.UI.ShowUpdateErrorDialog(err.Error())
+	}
+	u.doneChan <- err
 }
 
 func (u *Updater) Update() int {
-	AppendLogText(fmt.Sprintf("当前版本: %s", u.CurrentVer.Version))
-	AppendLogText("检查最新版本...")
+	return u.UpdateContext(context.Background())
+}
 
-	var err error
+// UpdateContext 与 Update 相同，ctx 取消时中断所有网络请求并返回 ExitCodeCancel
+//
+// 界面上的取消操作同样会取消 ctx。结束时发送 done 事件。
+func (u *Updater) UpdateContext(ctx context.Context) int {
+	u.startRun("update", true)
+	return u.finish(u.update(ctx))
+}
 
-	u.NewVer, err = u.checkLatestVersion()
-	if err != nil {
-		AppendLogText(fmt.Sprintf("检查更新时发生错误: %v", err))
-		return ExitCodeError
+// finish 记录运行结果，发送 done 事件并返回退出码
+func (u *Updater) finish(code int) int {
+	attrs := []any{"status", exitStatus(code), "exit_code", code,
+		"current_version", u.CurrentVer.Version, "version", u.NewVer.Version, "duration", time.Since(u.runStart)}
+	if u.lastErr != nil {
+		attrs = append(attrs, "error", u.lastErr.Error())
+	}
+	u.runLog.Info(fmt.Sprintf("运行结束: %s", exitStatus(code)), attrs...)
+	u.closeLog()
+
+	e := Event{
+		Type:           EventDone,
+		CurrentVersion: u.CurrentVer.Version,
+		Version:        u.NewVer.Version,
+		Status:         exitStatus(code),
+		ExitCode:       &code,
 	}
+	if u.lastErr != nil {
+		e.Error = u.lastErr.Error()
+	}
+	u.emit(e)
+	return code
+}
 
-	if u.NewVer.Version == u.CurrentVer.Version {
-		AppendLogText("没有新版本")
-		SetUpdateComplete()
-		return ExitCodeNoUpdate
+// prepare 检查配置并创建 HTTP 客户端
+func (u *Updater) prepare() error {
+	if u.configErr == nil {
+		u.configErr = u.Config.Validate()
 	}
+	if u.configErr == nil {
+		u.client, u.configErr = u.newHTTPClient()
+	}
+	return u.configErr
+}
 
-	if !IsSilentMode {
-		if !ShowUpdateConfirmDialog(fmt.Sprintf("发现新版本: %s,是否更新?", u.NewVer.Version)) {
-			AppendLogText("更新被用户取消")
-			CloseWindow()
-			return ExitCodeNoUpdate
+func (u *Updater) update(ctx context.Context) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go u.watchCancel(ctx, cancel)
+
+	cmp, code, ok := u.findUpdate(ctx)
+	if !ok {
+		return code
+	}
+
+	if u.NewVer.MinVersion != "" {
+		if c, _ := CompareVersions(u.CurrentVer.Version, u.NewVer.MinVersion); c < 0 {
+			u.log.Warn(fmt.Sprintf("当前版本低于 %s，无法直接更新到 %s", u.NewVer.MinVersion, u.NewVer.Version),
+				"min_version", u.NewVer.MinVersion)
+			if u.Silent {
+				return ExitCodeError
+			}
+			return u.handleManualUpdate(&u.NewVer)
 		}
 	}
 
-	u.syncUI()
-	u.bgTask()
+	message := fmt.Sprintf("发现新版本: %s,是否更新?", u.NewVer.Version)
+	if cmp < 0 {
+		u.log.Info(fmt.Sprintf("服务器要求回退到版本: %s", u.NewVer.Version), "version", u.NewVer.Version)
+		message = fmt.Sprintf("需要回退到版本: %s,是否继续?", u.NewVer.Version)
+	}
+
+	if !u.Silent {
+		// 等待确认时也要响应取消
+		confirmed := make(chan bool, 1)
+		go func() {
+			confirmed <- u.confirm(ctx, message)
+		}()
+
+		select {
+		case ok := <-confirmed:
+			if !ok {
+				u.log.Info("更新被用户取消")
+				u.UI.CloseWindow()
+				return ExitCodeNoUpdate
+			}
+		case <-ctx.Done():
+			u.log.Info("更新被取消")
+			return ExitCodeCancel
+		}
+	}
 
-	u.success = <-u.doneChan
-	AppendLogText("更新完成")
+	u.phase(phaseDownload)
+	stopSync := u.syncUI()
+	go u.bgTask(ctx)
+
+	err := <-u.doneChan
+	u.success = err == nil
+	u.lastErr = err
+	stopSync()
 
 	if u.success {
-		if !IsSilentMode {
-			SetUpdateComplete()
+		u.log.Info("更新完成", "version", u.NewVer.Version)
+		if code := u.startAndCheck(ctx); code != ExitCodeNewVersion {
+			return code
+		}
+		if !u.Silent {
+			u.UI.SetUpdateComplete()
 		}
 		return ExitCodeNewVersion
 	} else {
-		if !IsSilentMode {
-			ShowUpdateErrorDialog(err.Error())
+		u.log.Error("更新失败", "version", u.NewVer.Version)
+		if ctx.Err() != nil {
+			return ExitCodeCancel
+		}
+		if errors.Is(err, ErrSignature) {
+			return ExitCodeSignature
 		}
 		return ExitCodeError
 	}
 
 }
 
-func (u *Updater) checkLatestVersion() (VersionInfo, error) {
+// findUpdate 检查服务器上的版本，ok 为 false 时没有可以安装的版本，本次运行以 code 结束
+func (u *Updater) findUpdate(ctx context.Context) (cmp int, code int, ok bool) {
+	if err := u.prepare(); err != nil {
+		u.lastErr = err
+		u.log.Error(fmt.Sprintf("读取配置失败: %v", err))
+		return 0, ExitCodeError, false
+	}
+
+	u.phase(phaseCheck)
+	u.log.Info(fmt.Sprintf("当前版本: %s", u.CurrentVer.Version), "current_version", u.CurrentVer.Version, "channel", u.Channel)
+	u.log.Info("检查最新版本...")
+	u.emit(Event{Type: EventCheckStarted, CurrentVersion: u.CurrentVer.Version, Channel: u.Channel})
 
-	var vi VersionInfo
 	var err error
+	u.NewVer, err = u.checkLatestVersion(ctx)
+	if err != nil {
+		u.lastErr = err
+		u.log.Error(fmt.Sprintf("检查更新时发生错误: %v", err))
+		if ctx.Err() != nil {
+			return 0, ExitCodeCancel, false
+		}
+		if errors.Is(err, ErrSignature) {
+			return 0, ExitCodeSignature, false
+		}
+		return 0, ExitCodeError, false
+	}
 
-	for i := 0; i < RetryLimit; i++ {
-		client := u.getHTTPClient()
-		var url string
+	cmp, err = CompareVersions(u.NewVer.Version, u.CurrentVer.Version)
+	if err != nil {
+		u.lastErr = err
+		u.log.Error(fmt.Sprintf("检查更新时发生错误: %v", err))
+		return 0, ExitCodeError, false
+	}
 
-		url = VersionURL
-		if i > 2 {
-			url = fmt.Sprintf("%s/%s", ProxyURL, VersionURL)
-		} else {
-			url = VersionURL
-		}
+	available := cmp != 0
+	u.log.Info(fmt.Sprintf("最新版本: %s", u.NewVer.Version), "version", u.NewVer.Version, "channel", u.NewVer.Channel,
+		"bytes", u.NewVer.Size, "mandatory", u.NewVer.Mandatory)
+	u.emit(Event{
+		Type:            EventVersionFound,
+		CurrentVersion:  u.CurrentVer.Version,
+		Version:         u.NewVer.Version,
+		Channel:         u.NewVer.Channel,
+		Size:            u.NewVer.Size,
+		Notes:           u.NewVer.Notes,
+		UpdateAvailable: &available,
+	})
+
+	if cmp == 0 {
+		u.log.Info("没有新版本", "version", u.NewVer.Version)
+		u.UI.SetUpdateComplete()
+		return cmp, ExitCodeNoUpdate, false
+	}
 
-		var resp *http.Response
+	if u.versionFailed(u.NewVer.Version) {
+		u.log.Info(fmt.Sprintf("版本 %s 已被回滚，跳过", u.NewVer.Version), "version", u.NewVer.Version)
+		u.UI.SetUpdateComplete()
+		return cmp, ExitCodeNoUpdate, false
+	}
 
-		resp, err = client.Get(url)
-		if err != nil {
-			time.Sleep(time.Second)
-			continue
-		}
-		defer resp.Body.Close()
+	if u.NewVer.Notes != "" {
+		u.log.Info(fmt.Sprintf("更新说明: %s", u.NewVer.Notes))
+	}
 
-		vi.RawData, err = io.ReadAll(resp.Body)
-		if err != nil {
-			time.Sleep(time.Second)
-			continue
-		}
+	if cmp < 0 && !u.NewVer.Rollback {
+		u.log.Warn(fmt.Sprintf("服务器版本 %s 低于当前版本，拒绝降级", u.NewVer.Version), "version", u.NewVer.Version)
+		u.UI.SetUpdateComplete()
+		return cmp, ExitCodeNoUpdate, false
+	}
+	return cmp, 0, true
+}
 
-		cfg, err := ini.Load(vi.RawData)
+func (u *Updater) checkLatestVersion(ctx context.Context) (VersionInfo, error) {
+
+	var vi VersionInfo
+	var err error
+
+	// 失败时换到下一个镜像，镜像较少时同一镜像会重试多次
+	var mirror Mirror
+	var contentType string
+	var sig []byte
+	err = u.tryMirrors(ctx, func(m Mirror) error {
+		var err error
+		vi.RawData, contentType, err = u.fetchResource(ctx, m.ManifestURL)
 		if err != nil {
-			return vi, fmt.Errorf("无法解析版本信息: %v", err)
+			return err
 		}
+		mirror = m
+		sig, err = u.verifyManifest(ctx, m, vi.RawData)
+		return err
+	}, func(err error) bool {
+		return errors.Is(err, ErrSignature)
+	})
+	if errors.Is(err, ErrSignature) {
+		return vi, err
+	} else if err != nil {
+		return vi, fmt.Errorf("检查更新失败 %v", err)
+	}
 
-		vi.Version = cfg.Section("").Key("version").String()
-		vi.Filename = cfg.Section("").Key("filename").String()
-		vi.MD5 = cfg.Section("").Key("md5").String()
-		vi.FullPackageURL = cfg.Section("").Key("fullpackage").String()
+	releases, err := decodeManifest(vi.RawData, contentType, mirror.ManifestURL)
+	if err != nil {
+		return vi, err
+	}
 
-		if vi.Version == "" || vi.Filename == "" || vi.MD5 == "" || vi.FullPackageURL == "" {
-			return vi, fmt.Errorf("无效的版本文件格式")
+	// 先丢弃没有当前平台更新包的版本，再选出最高版本，
+	// 更高的版本暂时只发布到其他平台时不会影响本平台更新
+	latest, err := selectRelease(resolveReleases(releases, runtime.GOOS, runtime.GOARCH, u.Config.Variant), u.Channel)
+	if err != nil {
+		// 报告通道中最高版本不可用的原因，例如需要指定变体
+		if best, selErr := selectRelease(releases, u.Channel); selErr == nil {
+			if _, resErr := resolveArtifact(best, runtime.GOOS, runtime.GOARCH, u.Config.Variant); resErr != nil {
+				return vi, resErr
+			}
 		}
+		return vi, err
+	}
 
-		return vi, nil
+	if _, err := strongestDigest(latest.Digests, u.AllowMD5); err != nil {
+		return vi, err
 	}
 
-	return vi, fmt.Errorf("检查更新失败 %v", err)
+	latest.RawSignature = sig
+	return latest, nil
 }
 
-func (u *Updater) downloadAndUpdate() error {
-	// 构建下载 URL
-	url := fmt.Sprintf(ReleaseURL, u.NewVer.Version, u.NewVer.Filename)
+func (u *Updater) keyringPath() string {
+	return filepath.Join(filepath.Dir(u.versionFilePath), KeyringFile)
+}
 
-	// 在当前目录下创建 tmp 目录
-	tempDir := "tmp"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return fmt.Errorf("创建临时目录失败: %v", err)
+// loadKeyring 返回嵌入的公钥加上本机保存的轮换结果
+//
+// 正式版本必须嵌入公钥，只有调试模式允许返回空的公钥集合并跳过签名校验。
+func (u *Updater) loadKeyring() (*keyring, error) {
+	keys, err := newEmbeddedKeyring(u.TrustedKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSignature, err)
 	}
-	tempFilePath := filepath.Join(tempDir, u.NewVer.Filename)
+	if keys.Empty() {
+		if !u.debugMode {
+			return nil, fmt.Errorf("%w: 未嵌入签名公钥", ErrSignature)
+		}
+		return keys, nil
+	}
+
+	if err := keys.load(u.keyringPath()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSignature, err)
+	}
+	return keys, nil
+}
 
-	// 下载文件
-	err := u.downloadWithResume(url, tempFilePath)
+// verifyManifest 校验版本信息的签名，必要时先通过签名的公钥列表轮换公钥，返回版本信息的签名
+func (u *Updater) verifyManifest(ctx context.Context, mirror Mirror, data []byte) ([]byte, error) {
+	keys, err := u.loadKeyring()
 	if err != nil {
-		return fmt.Errorf("下载更新文件失败: %v", err)
+		return nil, err
 	}
+	u.keys = keys
 
-	// 验证 MD5
-	downloadedMD5, err := calculateMD5(tempFilePath)
-	if downloadedMD5 != u.NewVer.MD5 {
-		os.Remove(tempFilePath)
-		return fmt.Errorf("文件校验失败: %v", err)
+	if keys.Empty() {
+		u.log.Warn("未嵌入签名公钥，调试模式下跳过签名校验")
+		return nil, nil
+	}
+
+	keyListURL := mirror.KeyListURL()
+	list, err := u.fetch(ctx, keyListURL)
+	if err == nil {
+		var sig []byte
+		sig, err = u.fetch(ctx, keyListURL+SignatureSuffix)
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: 公钥列表缺少签名", ErrSignature)
+		} else if err != nil {
+			return nil, err
+		}
+		if err := keys.rotate(list, string(sig)); err != nil {
+			return nil, err
+		}
+		// 保存吊销记录，之后即使服务器上的公钥列表被删除也不会恢复被吊销的公钥
+		if !u.checkOnly {
+			if err := keys.save(u.keyringPath()); err != nil {
+				return nil, err
+			}
+		}
+	} else if isNotFound(err) {
+		// 轮换过公钥之后公钥列表不能消失，否则吊销记录可能被绕过
+		if keys.rotated {
+			return nil, fmt.Errorf("%w: 已经轮换过公钥，但服务器上没有公钥列表", ErrSignature)
+		}
+	} else {
+		return nil, err
+	}
+
+	sig, err := u.fetch(ctx, mirror.ManifestURL+SignatureSuffix)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("%w: 版本信息缺少签名", ErrSignature)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := keys.verify(data, string(sig)); err != nil {
+		return nil, fmt.Errorf("版本信息%w", err)
+	}
+	return sig, nil
+}
+
+func (u *Updater) downloadAndUpdate(ctx context.Context) error {
+	// 之前通过 download 命令下载好的同一版本、同一变体直接安装
+	staged, err := u.loadStaged()
+	if err != nil || staged.Version.Version != u.NewVer.Version || staged.Variant != u.Config.Variant {
+		staged, err = u.stage(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = u.extractAndReplace(tempFilePath)
+	tx, err := u.applyStaged(ctx, staged)
+	// 按文件更新失败时使用完整更新包，宿主程序没有退出时不再重试
+	if err != nil && staged.Package == "" && ctx.Err() == nil && !errors.Is(err, ErrWaitTimeout) {
+		u.log.Warn(fmt.Sprintf("按文件更新失败，使用完整更新包: %v", err))
+		u.phase(phaseDownload)
+		staged, err = u.stagePackage(ctx)
+		if err != nil {
+			return err
+		}
+		tx, err = u.applyStaged(ctx, staged)
+	}
 	if err != nil {
-		return fmt.Errorf("更新失败: %v", err)
+		return err
 	}
 
-	SetUpdateProgress(1.0)
+	return u.finishInstall(staged, tx)
+}
 
-	err = ioutil.WriteFile(VersionFile, u.NewVer.RawData, 0644)
+// stagePackage 通过补丁或完整下载得到更新包，校验摘要和签名
+func (u *Updater) stagePackage(ctx context.Context) (*stagedUpdate, error) {
+	tempFilePath := u.tempPath(u.NewVer.Filename)
 
+	digest, err := strongestDigest(u.NewVer.Digests, u.AllowMD5)
 	if err != nil {
-		return fmt.Errorf("更新版本文件失败: %v", err)
+		return nil, err
 	}
 
-	return nil
+	// 有适用于当前版本的补丁时先尝试增量更新，失败后下载完整更新包
+	patched := false
+	if patch, base, ok := u.selectPatch(); ok {
+		u.log.Info(fmt.Sprintf("使用增量更新: %s -> %s", patch.From, u.NewVer.Version), "patch", patch.Filename)
+		err := u.downloadPatched(ctx, patch, base, tempFilePath, digest)
+		if err == nil {
+			patched = true
+		} else if ctx.Err() != nil {
+			return nil, fmt.Errorf("下载更新文件失败: %v", err)
+		} else {
+			u.log.Warn(fmt.Sprintf("增量更新失败，下载完整更新包: %v", err), "patch", patch.Filename)
+		}
+	}
+
+	if !patched {
+		hash := digest.newHash()
+
+		// 下载文件，同时计算摘要
+		err = u.download(ctx, downloadTarget{Filename: u.NewVer.Filename, Size: u.NewVer.Size, Digest: digest}, tempFilePath, hash)
+		if err != nil {
+			return nil, fmt.Errorf("下载更新文件失败: %v", err)
+		}
+
+		// 验证摘要
+		if err := digest.Verify(hash); err != nil {
+			discardPartial(tempFilePath)
+			u.emit(Event{Type: EventVerifyFailed, Version: u.NewVer.Version, Error: err.Error()})
+			return nil, fmt.Errorf("文件校验失败: %v", err)
+		}
+	}
+
+	// 验证签名
+	if !u.keys.Empty() {
+		if err := u.keys.verifyPackage(tempFilePath, u.NewVer.Signature); err != nil {
+			os.Remove(tempFilePath)
+			u.emit(Event{Type: EventVerifyFailed, Version: u.NewVer.Version, Error: err.Error()})
+			return nil, err
+		}
+	}
+	u.emit(Event{Type: EventVerified, Version: u.NewVer.Version})
+
+	return &stagedUpdate{Version: u.NewVer, Package: tempFilePath, Variant: u.Config.Variant}, nil
 }
 
-func (u *Updater) downloadWithResume(url string, filePath string) error {
+// downloadWithResume 断点续传下载文件，已下载和新写入的内容都会写入 hash
+//
+// 续传时带上 If-Range，服务器上的文件变化后会返回完整文件而不是拼接到旧内容后面。
+func (u *Updater) downloadWithResume(ctx context.Context, mirror Mirror, filePath string, state *downloadState, hash hash.Hash) error {
+	url := mirror.packageURL(u.NewVer.Version, state.filename)
+	u.log.Debug(fmt.Sprintf("下载: %s", url), "url", url, "mirror", mirror.Name)
+	hash.Reset()
+
+	// 分段下载留下的文件已预分配到完整大小，不能按文件长度续传
+	if state.ChunkSize > 0 {
+		discardPartial(filePath)
+		state.reset(state.Version, state.Digest)
+	}
+
 	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return err
@@ -278,12 +693,29 @@ func (u *Updater) downloadWithResume(url string, filePath string) error {
 	downloadedSize := fileInfo.Size()
 	totalSize := int64(0)
 
-	req, err := http.NewRequest("GET", url, nil)
+	if downloadedSize > 0 && (state.Size == 0 || downloadedSize > state.Size) {
+		// 没有记录服务器文件信息的内容无法确认来源，重新下载
+		if err := file.Truncate(0); err != nil {
+			return fmt.Errorf("清空文件失败: %v", err)
+		}
+		downloadedSize = 0
+	}
+	if downloadedSize > 0 && downloadedSize == state.Size {
+		// 上次已下载完整，只是没有完成校验
+		u.SetProgress(0.9)
+		_, err := io.Copy(hash, file)
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
 	if downloadedSize > 0 {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", downloadedSize))
+		if v := state.validator(mirror.Name); v != "" {
+			req.Header.Set("If-Range", v)
+		}
 	}
 
 	client := u.getHTTPClient()
@@ -295,7 +727,7 @@ func (u *Updater) downloadWithResume(url string, filePath string) error {
 
 	switch resp.StatusCode {
 	case http.StatusOK:
-		// 服务器不支持断点续传，清空文件并重新下载
+		// 服务器不支持断点续传或文件已变化，清空文件并重新下载
 		if err := file.Truncate(0); err != nil {
 			return fmt.Errorf("清空文件失败: %v", err)
 		}
@@ -304,10 +736,21 @@ func (u *Updater) downloadWithResume(url string, filePath string) error {
 		}
 		totalSize = resp.ContentLength
 		downloadedSize = 0
+		hash.Reset()
+
+		state.setRemote(mirror.Name, totalSize, resp.Header)
+		if err := state.save(); err != nil {
+			return fmt.Errorf("保存下载记录失败: %v", err)
+		}
 	case http.StatusPartialContent:
 		totalSize = resp.ContentLength + downloadedSize
-		if _, err := file.Seek(downloadedSize, 0); err != nil {
-			return fmt.Errorf("设置文件指针失败: %v", err)
+		if !state.sameRemote(mirror.Name, contentRangeSize(resp.Header.Get("Content-Range")), resp.Header) ||
+			!strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", downloadedSize)) {
+			return errRemoteChanged
+		}
+		// 已下载部分先计入摘要，读取结束后文件指针正好位于续传位置
+		if _, err := io.CopyN(hash, file, downloadedSize); err != nil {
+			return fmt.Errorf("读取已下载内容失败: %v", err)
 		}
 
 		if totalSize == downloadedSize {
@@ -316,7 +759,7 @@ func (u *Updater) downloadWithResume(url string, filePath string) error {
 		}
 
 	default:
-		return fmt.Errorf("服务器返回非预期状态码: %d", resp.StatusCode)
+		return newHTTPStatusError(url, resp)
 	}
 
 	if totalSize <= 0 {
@@ -330,11 +773,10 @@ func (u *Updater) downloadWithResume(url string, filePath string) error {
 		buffer = make([]byte, 32*1024)
 	}
 	for {
-		if IsUpdateCancelled() {
-			return fmt.Errorf("下载被用户取消")
-		}
 		if u.debugMode {
-			time.Sleep(100 * time.Millisecond)
+			if err := sleepContext(ctx, 100*time.Millisecond); err != nil {
+				return err
+			}
 		}
 		n, err := resp.Body.Read(buffer)
 		if n > 0 {
@@ -342,6 +784,7 @@ func (u *Updater) downloadWithResume(url string, filePath string) error {
 			if writeErr != nil {
 				return writeErr
 			}
+			hash.Write(buffer[:n])
 			downloadedSize += int64(n)
 			progress := float64(downloadedSize) / float64(totalSize)
 			if progress > 0.9 {
@@ -423,7 +866,7 @@ func (u *Updater) downloadWithResume2(url string, filePath string, progressChan
 		buffer = make([]byte, 32*1024)
 	}
 	for {
-		if IsUpdateCancelled() {
+		if u.UI.IsUpdateCancelled() {
 			return fmt.Errorf("下载被用户取消")
 		}
 		if u.debugMode {
@@ -456,52 +899,25 @@ func (u *Updater) downloadWithResume2(url string, filePath string, progressChan
 	return nil
 }
 
-func (u *Updater) extractAndReplace(zipPath string) error {
-	reader, err := zip.OpenReader(zipPath)
+// extractAndReplace 将更新包解压到暂存目录后替换安装目录中的文件
+//
+// 返回的事务在本地版本文件更新后由调用方提交，失败时可以回滚
+func (u *Updater) extractAndReplace(zipPath string) (*installTransaction, error) {
+	tx, err := newInstallTransaction(u.dir)
 	if err != nil {
-		return fmt.Errorf("打开 ZIP 文件失败: %v", err)
+		return nil, err
 	}
-	defer reader.Close()
 
-	// 获取当前可执行文件的目录
-	execDir, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("获取当前目录失败: %v", err)
+	if err := tx.stageZip(zipPath, u.Config.ArchiveLimits); err != nil {
+		tx.rollback()
+		return nil, err
 	}
 
-	for _, file := range reader.File {
-		filePath := filepath.Join(execDir, file.Name)
-
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(filePath, os.ModePerm)
-			continue
-		}
-
-		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
-			return fmt.Errorf("创建目录失败: %v", err)
-		}
-
-		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return fmt.Errorf("创建文件失败: %v", err)
-		}
-
-		srcFile, err := file.Open()
-		if err != nil {
-			dstFile.Close()
-			return fmt.Errorf("打开 ZIP 文件内容失败: %v", err)
-		}
-
-		_, err = io.Copy(dstFile, srcFile)
-		srcFile.Close()
-		dstFile.Close()
-
-		if err != nil {
-			return fmt.Errorf("复制文件内容失败: %v", err)
-		}
+	if err := tx.apply(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return tx, nil
 }
 
 // SetProgress 设置更新进度
@@ -541,8 +957,16 @@ func (u *Updater) replaceUnixExecutable(tempPath, execPath string) error {
 	return os.Rename(tempPath, execPath)
 }
 
+// confirm 显示确认对话框，界面支持时 ctx 取消后对话框立即返回
+func (u *Updater) confirm(ctx context.Context, message string) bool {
+	if c, ok := u.UI.(ContextConfirmer); ok {
+		return c.ShowUpdateConfirmDialogContext(ctx, message)
+	}
+	return u.UI.ShowUpdateConfirmDialog(message)
+}
+
 func (u *Updater) handleManualUpdate(versionInfo *VersionInfo) int {
-	manualUpdate := ShowUpdateConfirmDialog("是否打开浏览器下载完整安装包?")
+	manualUpdate := u.UI.ShowUpdateConfirmDialog("是否打开浏览器下载完整安装包?")
 	if manualUpdate {
 		u.openBrowser(versionInfo.FullPackageURL)
 		return ExitCodeNewVersion
@@ -566,75 +990,167 @@ func (u *Updater) openBrowser(url string) {
 	}
 
 	if err != nil {
-		ShowUpdateErrorDialog(fmt.Sprintf("无法打开浏览器: %v", err))
+		u.UI.ShowUpdateErrorDialog(fmt.Sprintf("无法打开浏览器: %v", err))
 	}
 }
 
-func calculateMD5(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+func ReadVersionFile(filePath string) (vi VersionInfo, err error) {
+	var content []byte
+	content, err = ioutil.ReadFile(filePath)
 	if err != nil {
-		return "", err
+		return vi, fmt.Errorf("无法读取版本文件: %v", err)
 	}
-	defer file.Close()
 
-	hash := md5.New()
+	releases, err := decodeManifest(content, "", filePath)
+	if err != nil {
+		return vi, err
+	}
+	if len(releases) != 1 {
+		return vi, fmt.Errorf("本地版本文件只能包含一个版本")
+	}
+
+	return releases[0], nil
+}
+
+// writeVersionFile 保存本地已安装的版本信息
+func writeVersionFile(filePath string, vi VersionInfo) error {
+	cfg := ini.Empty()
+	section := cfg.Section("")
+	section.Key("version").SetValue(vi.Version)
+	section.Key("channel").SetValue(vi.Channel)
+	section.Key("filename").SetValue(vi.Filename)
+	for _, algo := range digestAlgorithms {
+		if value, ok := vi.Digests[algo]; ok {
+			section.Key(algo).SetValue(value)
+		}
+	}
+	section.Key("fullpackage").SetValue(vi.FullPackageURL)
 
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
+	// 先写入临时文件再重命名，避免留下不完整的版本文件
+	tmpPath := filePath + ".tmp"
+	if err := cfg.SaveTo(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
 	}
+	return os.Rename(tmpPath, filePath)
+}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+func (u *Updater) channelFilePath() string {
+	return filepath.Join(filepath.Dir(u.versionFilePath), ChannelFile)
 }
 
-func ReadVersionFile(filePath string) (vi VersionInfo, err error) {
-	var content []byte
-	content, err = ioutil.ReadFile(filePath)
+// SetChannel 切换发布通道并保存在本地版本文件旁
+func (u *Updater) SetChannel(name string) error {
+	channel, err := normalizeChannel(name)
 	if err != nil {
-		return vi, fmt.Errorf("无法读取版本文件: %v", err)
+		return err
 	}
+	if err := writeChannelFile(u.channelFilePath(), channel); err != nil {
+		return err
+	}
+	u.Channel = channel
+	return nil
+}
 
-	cfg, err := ini.Load(content)
+// UseChannel 只在本次运行中使用指定的发布通道，不保存
+func (u *Updater) UseChannel(name string) error {
+	channel, err := normalizeChannel(name)
 	if err != nil {
-		return vi, fmt.Errorf("无法解析版本信息: %v", err)
+		return err
 	}
+	u.Channel = channel
+	return nil
+}
 
-	vi.Version = cfg.Section("").Key("version").String()
-	vi.Filename = cfg.Section("").Key("filename").String()
-	vi.MD5 = cfg.Section("").Key("md5").String()
-	vi.FullPackageURL = cfg.Section("").Key("fullpackage").String()
+// httpStatusError 服务器返回了非预期的状态码
+type httpStatusError struct {
+	URL        string
+	StatusCode int
+	// RetryAfter 429、503 等响应中 Retry-After 要求的等待时间
+	RetryAfter time.Duration
+}
 
-	if vi.Version == "" || vi.Filename == "" || vi.MD5 == "" || vi.FullPackageURL == "" {
-		return vi, fmt.Errorf("配置文件缺少必要的信息")
+func newHTTPStatusError(url string, resp *http.Response) *httpStatusError {
+	return &httpStatusError{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
 	}
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("服务器返回非预期状态码: %d (%s)", e.StatusCode, e.URL)
+}
+
+func isNotFound(err error) bool {
+	var statusErr *httpStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// fetch 下载较小的文本资源（版本信息、签名、公钥列表）
+func (u *Updater) fetch(ctx context.Context, url string) ([]byte, error) {
+	data, _, err := u.fetchResource(ctx, url)
+	return data, err
+}
 
-	version := cfg.Section("").Key("version").String()
-	if version == "" {
-		return vi, fmt.Errorf("版本信息不存在")
+// fetchResource 与 fetch 相同，同时返回 Content-Type
+func (u *Updater) fetchResource(ctx context.Context, url string) ([]byte, string, error) {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", err
 	}
 
-	return
+	client := u.getHTTPClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", newHTTPStatusError(url, resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	u.log.Debug(fmt.Sprintf("请求完成: %s", url), "url", url, "bytes", len(data), "duration", time.Since(start))
+	return data, resp.Header.Get("Content-Type"), err
 }
 
 func (u *Updater) getHTTPClient() *http.Client {
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	return u.client
+}
+
+// newHTTPClient 按配置构造 HTTP 客户端，整个更新过程共用
+//
+// 调试模式下所有连接都转到 127.0.0.1:9808 上的 debug_server.py，https 地址仍然使用 TLS。
+func (u *Updater) newHTTPClient() (*http.Client, error) {
+	insecure := u.InsecureSkipVerify && u.debugMode
+	if insecure {
+		u.log.Warn("警告: 已跳过证书校验，仅供调试使用")
+	}
+	tlsConfig, err := newTLSConfig(u.Config.TLS, insecure)
+	if err != nil {
+		return nil, err
 	}
 
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
 	if u.debugMode {
-		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: time.Second * 2,
-			}
-			return d.DialContext(ctx, "tcp", "127.0.0.1:9808")
-		}
-		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		u.log.Warn(fmt.Sprintf("调试模式: 所有连接都转到 %s", debugServerAddr))
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
 			d := net.Dialer{
 				Timeout: time.Second * 2,
 			}
-			return d.DialContext(ctx, "tcp", "127.0.0.1:9808")
+			return d.DialContext(ctx, "tcp", debugServerAddr)
 		}
 	}
 
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		DialContext:     dial,
+		DialTLSContext:  tlsDialer(tlsConfig, u.Config.TLS.Pins, dial),
+	}
+
 	return &http.Client{
 		Transport: transport,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
@@ -643,5 +1159,5 @@ func (u *Updater) getHTTPClient() *http.Client {
 			}
 			return nil
 		},
-	}
+	}, nil
 }
@@ -0,0 +1,169 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CloseNone   = "none"
+	CloseSignal = "signal"
+	CloseIPC    = "ipc"
+
+	DefaultWaitTimeout = time.Minute
+
+	// closeMessage ipc 方式发送给宿主程序的关闭请求
+	closeMessage     = "close\n"
+	closeDialTimeout = 3 * time.Second
+	waitPollInterval = 200 * time.Millisecond
+	maxLockFileSize  = 64
+)
+
+// ErrWaitTimeout 宿主程序在超时时间内没有退出
+var ErrWaitTimeout = errors.New("等待程序退出超时")
+
+// WaitConfig 安装前等待宿主程序退出，避免替换仍被占用的文件
+//
+//	[wait]
+//	lock_file  = app.lock
+//	timeout    = 60s
+//	close      = ipc
+//	close_addr = 127.0.0.1:47001
+type WaitConfig struct {
+	// PID 宿主程序的进程号，通常由宿主程序通过 -wait-pid 传入
+	PID int
+	// LockFile 宿主程序运行期间持有（Unix 上 flock，Windows 上独占打开）的锁文件，
+	// 内容为进程号且没有指定 PID 时同时等待该进程
+	LockFile string
+	Timeout  time.Duration
+	// Close 请求宿主程序关闭的方式: none 只等待，signal 发送 SIGTERM（不支持 Windows），
+	// ipc 连接 CloseAddr 并发送一行 "close"
+	Close     string
+	CloseAddr string
+}
+
+// DefaultWaitConfig 默认只等待，不请求宿主程序关闭
+func DefaultWaitConfig() WaitConfig {
+	return WaitConfig{Timeout: DefaultWaitTimeout, Close: CloseNone}
+}
+
+// Validate 检查等待配置
+func (c WaitConfig) Validate() error {
+	if c.PID < 0 {
+		return fmt.Errorf("无效的进程号: %d", c.PID)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("等待时间必须大于 0: %v", c.Timeout)
+	}
+
+	switch c.Close {
+	case CloseNone:
+	case CloseSignal:
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("Windows 不支持通过信号关闭程序，请使用 ipc")
+		}
+	case CloseIPC:
+		host, _, err := net.SplitHostPort(c.CloseAddr)
+		if err != nil {
+			return fmt.Errorf("无效的关闭请求地址: %q", c.CloseAddr)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("关闭请求只能发送到本机地址: %q", c.CloseAddr)
+		}
+	default:
+		return fmt.Errorf("未知的关闭方式: %q", c.Close)
+	}
+	return nil
+}
+
+// lockFilePID 读取锁文件中的进程号，没有时返回 0
+func lockFilePID(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxLockFileSize))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
+
+// waitForApp 宿主程序仍在运行时按配置请求它关闭，并等待它退出
+func (u *Updater) waitForApp(ctx context.Context) error {
+	w := u.Config.Wait
+
+	pid := w.PID
+	if pid == 0 && w.LockFile != "" {
+		pid = lockFilePID(w.LockFile)
+	}
+	running := func() bool {
+		return (pid > 0 && processRunning(pid)) || (w.LockFile != "" && lockHeld(w.LockFile))
+	}
+
+	if !running() {
+		return nil
+	}
+
+	start := time.Now()
+	if pid > 0 {
+		u.log.Info(fmt.Sprintf("等待程序 (PID %d) 退出...", pid), "pid", pid)
+	} else {
+		u.log.Info(fmt.Sprintf("等待程序释放 %s...", w.LockFile), "lock_file", w.LockFile)
+	}
+
+	if err := requestClose(w, pid); err != nil {
+		// 请求失败时仍然等待，用户可以手动关闭程序
+		u.log.Warn(fmt.Sprintf("请求程序关闭失败: %v", err), "close", w.Close)
+	}
+
+	deadline := time.Now().Add(w.Timeout)
+	for running() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w (%v)", ErrWaitTimeout, w.Timeout)
+		}
+		if err := sleepContext(ctx, waitPollInterval); err != nil {
+			return err
+		}
+	}
+
+	u.log.Info("程序已退出", "duration", time.Since(start))
+	return nil
+}
+
+// requestClose 按配置的方式请求宿主程序关闭
+func requestClose(w WaitConfig, pid int) error {
+	switch w.Close {
+	case CloseSignal:
+		if pid <= 0 {
+			return fmt.Errorf("没有宿主程序的进程号")
+		}
+		return terminateProcess(pid)
+	case CloseIPC:
+		conn, err := net.DialTimeout("tcp", w.CloseAddr, closeDialTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(closeDialTimeout))
+		_, err = io.WriteString(conn, closeMessage)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+//go:build linux
+// +build linux
+
+package updater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newWaitTestUpdater(t *testing.T, w WaitConfig) *Updater {
+	t.Helper()
+
+	dir := t.TempDir()
+	u := newUpdater(dir, dir, NewEventUI(nil))
+	if u.configErr != nil {
+		t.Fatal(u.configErr)
+	}
+	if w.Close == "" {
+		w.Close = CloseNone
+	}
+	u.Config.Wait = w
+	return u
+}
+
+// startSleep 启动子进程 sleep，files 会被子进程继承；测试结束时结束并回收子进程
+func startSleep(t *testing.T, d time.Duration, files ...*os.File) *exec.Cmd {
+	t.Helper()
+
+	cmd := exec.Command("sleep", strconv.FormatFloat(d.Seconds(), 'f', 3, 64))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		t.Skipf("无法启动 sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+// lockBySleep 创建锁文件并加上 flock，由子进程继承后关闭本进程的文件，
+// 锁在子进程退出时释放
+func lockBySleep(t *testing.T, d time.Duration, content string) (string, *exec.Cmd) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "app.lock")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	return path, startSleep(t, d, file)
+}
+
+func TestWaitForAppPID(t *testing.T) {
+	cmd := startSleep(t, 300*time.Millisecond)
+	pid := cmd.Process.Pid
+	if !processRunning(pid) {
+		t.Fatalf("processRunning(%d) = false", pid)
+	}
+
+	// 子进程退出后没有被回收，僵尸进程同样视为已退出
+	u := newWaitTestUpdater(t, WaitConfig{PID: pid, Timeout: 5 * time.Second})
+	start := time.Now()
+	if err := u.waitForApp(context.Background()); err != nil {
+		t.Fatalf("waitForApp() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("waitForApp() 在 %v 后返回，子进程还没有退出", elapsed)
+	}
+	if processRunning(pid) {
+		t.Fatalf("processRunning(%d) = true", pid)
+	}
+}
+
+func TestWaitForAppPIDTimeout(t *testing.T) {
+	cmd := startSleep(t, 10*time.Second)
+
+	u := newWaitTestUpdater(t, WaitConfig{PID: cmd.Process.Pid, Timeout: 300 * time.Millisecond})
+	err := u.waitForApp(context.Background())
+	if !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("waitForApp() = %v, want %v", err, ErrWaitTimeout)
+	}
+}
+
+func TestWaitForAppLockFile(t *testing.T) {
+	path, _ := lockBySleep(t, 300*time.Millisecond, "")
+	if !lockHeld(path) {
+		t.Fatalf("lockHeld(%s) = false", path)
+	}
+
+	u := newWaitTestUpdater(t, WaitConfig{LockFile: path, Timeout: 5 * time.Second})
+	if err := u.waitForApp(context.Background()); err != nil {
+		t.Fatalf("waitForApp() = %v", err)
+	}
+	if lockHeld(path) {
+		t.Fatalf("lockHeld(%s) = true", path)
+	}
+}
+
+func TestWaitForAppLockFileTimeout(t *testing.T) {
+	path, _ := lockBySleep(t, 10*time.Second, "")
+
+	u := newWaitTestUpdater(t, WaitConfig{LockFile: path, Timeout: 300 * time.Millisecond})
+	err := u.waitForApp(context.Background())
+	if !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("waitForApp() = %v, want %v", err, ErrWaitTimeout)
+	}
+}
+
+func TestWaitForAppLockFilePID(t *testing.T) {
+	// 没有加锁，只记录了进程号的锁文件
+	cmd := startSleep(t, 300*time.Millisecond)
+	path := filepath.Join(t.TempDir(), "app.lock")
+	if err := os.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := lockFilePID(path); got != cmd.Process.Pid {
+		t.Fatalf("lockFilePID() = %d, want %d", got, cmd.Process.Pid)
+	}
+	if !lockHeld(path) {
+		t.Fatalf("lockHeld(%s) = false", path)
+	}
+
+	u := newWaitTestUpdater(t, WaitConfig{LockFile: path, Timeout: 5 * time.Second})
+	if err := u.waitForApp(context.Background()); err != nil {
+		t.Fatalf("waitForApp() = %v", err)
+	}
+}
+
+func TestWaitForAppNotRunning(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("无法运行 true: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "missing.lock")
+
+	u := newWaitTestUpdater(t, WaitConfig{PID: cmd.Process.Pid, LockFile: path, Timeout: time.Second})
+	start := time.Now()
+	if err := u.waitForApp(context.Background()); err != nil {
+		t.Fatalf("waitForApp() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > waitPollInterval {
+		t.Fatalf("waitForApp() 等待了 %v", elapsed)
+	}
+}
+
+func TestWaitForAppCancel(t *testing.T) {
+	cmd := startSleep(t, 10*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	u := newWaitTestUpdater(t, WaitConfig{PID: cmd.Process.Pid, Timeout: 5 * time.Second})
+	if err := u.waitForApp(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waitForApp() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
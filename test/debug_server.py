@@ -3,6 +3,13 @@ import mimetypes
 from http.server import HTTPServer, SimpleHTTPRequestHandler
 import json
 import re
+import ssl
+import subprocess
+import sys
+
+# 自签名证书，首次运行时生成；更新程序需要 -debug -insecure-skip-verify 才会接受
+CERT_FILE = "debug_cert.pem"
+KEY_FILE = "debug_key.pem"
 
 class DebugHandler(SimpleHTTPRequestHandler):
     def __init__(self, *args, **kwargs):
@@ -63,7 +70,16 @@ class DebugHandler(SimpleHTTPRequestHandler):
             else:
                 self.send_error(404, "File not found")
 
-def run_server(port=9808):
+def ensure_cert(script_dir):
+    cert = os.path.join(script_dir, CERT_FILE)
+    key = os.path.join(script_dir, KEY_FILE)
+    if not (os.path.isfile(cert) and os.path.isfile(key)):
+        subprocess.run(["openssl", "req", "-x509", "-newkey", "rsa:2048", "-nodes",
+                        "-keyout", key, "-out", cert, "-days", "365", "-subj", "/CN=localhost"],
+                       check=True)
+    return cert, key
+
+def run_server(port=9808, use_tls=True):
 
     # Get the directory of the script
     script_dir = os.path.dirname(os.path.abspath(__file__))
@@ -75,9 +91,17 @@ def run_server(port=9808):
     
     server_address = ('', port)
     httpd = HTTPServer(server_address, DebugHandler)
-    print(f"http server for debug is running on port: {port}")
+    scheme = "http"
+    if use_tls:
+        cert, key = ensure_cert(script_dir)
+        context = ssl.SSLContext(ssl.PROTOCOL_TLS_SERVER)
+        context.load_cert_chain(cert, key)
+        httpd.socket = context.wrap_socket(httpd.socket, server_side=True)
+        scheme = "https"
+    print(f"{scheme} server for debug is running on port: {port}")
     print(f"Root directory set to: {test_files_dir}")
     httpd.serve_forever()
 
 if __name__ == "__main__":
-    run_server()
\ No newline at end of file
+    # --http 提供明文 HTTP，用于 http:// 的更新源
+    run_server(use_tls="--http" not in sys.argv[1:])